@@ -0,0 +1,249 @@
+package accesslog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const (
+	COMMON   = "common"   //Common Log Format followed by service/version, target, duration and request ID.
+	COMBINED = "combined" //Combined Log Format followed by service/version, target, duration and request ID.
+	JSON     = "json"     //One JSON object per line.
+	TEMPLATE = "template" //Custom text/template executed with the Entry.
+)
+
+var (
+	ErrUnknownFormat = errors.New("accesslog: format must be common, combined, json or template")
+	ErrNoTemplate    = errors.New("accesslog: template format requires a template")
+)
+
+type Settings struct {
+	Format     string //common, combined (default), json or template.
+	Template   string //text/template executed with the Entry of each request; used by the template format.
+	Path       string //File the log is appended to; stdout if blank.
+	MaxSizeMB  int    //Size at which the file is rotated; defaults to 100.
+	MaxBackups int    //Rotated files kept as Path.1 to Path.N; defaults to 5.
+}
+
+//A proxied request as recorded in the access log.
+type Entry struct {
+	Time      time.Time     //Time the request was received.
+	ClientIP  string        //Address of the client without the port.
+	Method    string        //Request method.
+	Path      string        //Request URI as received, including the service/version prefix.
+	Proto     string        //Request protocol.
+	Service   string        //Resolved service; empty if the request could not be resolved.
+	Version   string        //Resolved version; empty if the request could not be resolved.
+	Target    string        //Address of the target the request was last forwarded to; empty if none.
+	Status    int           //Status sent to the client.
+	Bytes     int64         //Response body bytes sent to the client.
+	Duration  time.Duration //Time until the response was completed.
+	Referer   string        //Referer header.
+	UserAgent string        //User-Agent header.
+	RequestID string        //Request ID received or generated; empty if request IDs are disabled.
+	TraceID   string        //W3C trace ID of the request; empty if trace propagation is disabled.
+}
+
+type Logger struct {
+	lock   sync.Mutex //Exclusive lock for the output.
+	output *Output    //Applied format and destination; nil until configured.
+}
+
+//Format and destination of the log opened by Open to be applied to a logger with Apply.
+type Output struct {
+	format   string             //Format of each line.
+	template *template.Template //Parsed template of the template format.
+	out      io.Writer          //Destination of the log; nil if disabled.
+	file     *rotatingFile      //Open log file; nil if logging to stdout or disabled.
+}
+
+//Creates a logger that discards entries until it is configured.
+func NewLogger() *Logger {
+	return &Logger{}
+}
+
+//Reports whether the settings can be applied. Returns ErrUnknownFormat or ErrNoTemplate, or
+//the parse error of the template.
+func (s Settings) Validate() error {
+	_, err := s.parse()
+	return err
+}
+
+func (s Settings) parse() (*template.Template, error) {
+	switch s.Format {
+	case "", COMMON, COMBINED, JSON:
+		return nil, nil
+	case TEMPLATE:
+		if s.Template == "" {
+			return nil, ErrNoTemplate
+		}
+		return template.New("accesslog").Parse(s.Template)
+	}
+	return nil, ErrUnknownFormat
+}
+
+//Replaces the format and output of the log. A nil settings disables logging. The previous log
+//file is closed. Returns an error and keeps the current configuration if the settings are
+//invalid or the file cannot be opened.
+func (l *Logger) Configure(s *Settings) error {
+	o, err := Open(s)
+	if err != nil {
+		return err
+	}
+	l.Apply(o)
+	return nil
+}
+
+//Parses the settings and opens the log file without applying them to a logger. A nil settings
+//disables logging. Returns an error if the settings are invalid or the file cannot be opened.
+func Open(s *Settings) (*Output, error) {
+	var (
+		tmpl *template.Template
+		out  io.Writer
+		file *rotatingFile
+		err  error
+	)
+	if s != nil {
+		if tmpl, err = s.parse(); err != nil {
+			return nil, err
+		}
+		out = os.Stdout
+		if s.Path != "" {
+			maxSize, maxBackups := s.MaxSizeMB, s.MaxBackups
+			if maxSize <= 0 {
+				maxSize = 100
+			}
+			if maxBackups <= 0 {
+				maxBackups = 5
+			}
+			if file, err = openRotatingFile(s.Path, int64(maxSize)<<20, maxBackups); err != nil {
+				return nil, err
+			}
+			out = file
+		}
+	}
+	o := &Output{format: COMBINED, template: tmpl, out: out, file: file}
+	if s != nil && s.Format != "" {
+		o.format = s.Format
+	}
+	return o, nil
+}
+
+//Closes the log file of the output. Used for an output that is not going to be applied; Apply
+//closes the output it replaces.
+func (o *Output) Close() error {
+	if o == nil || o.file == nil {
+		return nil
+	}
+	return o.file.Close()
+}
+
+//Replaces the format and output of the log with o. The previous output is closed.
+func (l *Logger) Apply(o *Output) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	previous := l.output
+	l.output = o
+	if err := previous.Close(); err != nil {
+		log.Print("accesslog: ", err)
+	}
+}
+
+//Writes the entry as a single line. Does nothing if the logger is nil or not configured.
+func (l *Logger) Log(e Entry) {
+	if l == nil {
+		return
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	o := l.output
+	if o == nil || o.out == nil {
+		return
+	}
+	var line string
+	switch o.format {
+	case COMMON:
+		line = common(e) + extra(e)
+	case COMBINED:
+		line = common(e) + fmt.Sprintf(" %q %q", dash(e.Referer), dash(e.UserAgent)) + extra(e)
+	case JSON:
+		data, err := json.Marshal(jsonEntry{
+			Time: e.Time, ClientIP: e.ClientIP, Method: e.Method, Path: e.Path, Proto: e.Proto,
+			Service: e.Service, Version: e.Version, Target: e.Target, Status: e.Status, Bytes: e.Bytes,
+			DurationMs: float64(e.Duration) / float64(time.Millisecond), Referer: e.Referer, UserAgent: e.UserAgent,
+			RequestID: e.RequestID, TraceID: e.TraceID,
+		})
+		if err != nil {
+			log.Print("accesslog: ", err)
+			return
+		}
+		line = string(data)
+	case TEMPLATE:
+		var b strings.Builder
+		if err := o.template.Execute(&b, e); err != nil {
+			log.Print("accesslog: ", err)
+			return
+		}
+		line = strings.TrimRight(b.String(), "\n")
+	}
+	if _, err := io.WriteString(o.out, line+"\n"); err != nil {
+		log.Print("accesslog: ", err)
+	}
+}
+
+//Closes the log file if one is open.
+func (l *Logger) Close() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	err := l.output.Close()
+	l.output = nil
+	return err
+}
+
+type jsonEntry struct {
+	Time       time.Time
+	ClientIP   string
+	Method     string
+	Path       string
+	Proto      string
+	Service    string
+	Version    string
+	Target     string
+	Status     int
+	Bytes      int64
+	DurationMs float64
+	Referer    string
+	UserAgent  string
+	RequestID  string `json:",omitempty"`
+	TraceID    string `json:",omitempty"`
+}
+
+//Formats the Common Log Format fields of the entry.
+func common(e Entry) string {
+	return fmt.Sprintf("%s - - [%s] \"%s %s %s\" %d %d",
+		dash(e.ClientIP), e.Time.Format("02/Jan/2006:15:04:05 -0700"), e.Method, e.Path, e.Proto, e.Status, e.Bytes)
+}
+
+//Formats the fields appended to the Common and Combined Log Formats.
+func extra(e Entry) string {
+	svc := "-"
+	if e.Service != "" {
+		svc = e.Service + "/" + e.Version
+	}
+	return fmt.Sprintf(" %s %s %dms %s", svc, dash(e.Target), e.Duration.Milliseconds(), dash(e.RequestID))
+}
+
+func dash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
@@ -0,0 +1,167 @@
+package accesslog_test
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/cbergoon/glb/accesslog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var entry = accesslog.Entry{
+	Time:      time.Date(2000, time.October, 10, 13, 55, 36, 0, time.FixedZone("", -7*3600)),
+	ClientIP:  "127.0.0.1",
+	Method:    "GET",
+	Path:      "/testSvc01/testKey01/index.html?q=1",
+	Proto:     "HTTP/1.1",
+	Service:   "testSvc01",
+	Version:   "testKey01",
+	Target:    "localhost:8080",
+	Status:    200,
+	Bytes:     2326,
+	Duration:  1500 * time.Millisecond,
+	UserAgent: "test-agent",
+	RequestID: "3f2a",
+}
+
+//Configures a logger writing to a file in a temporary directory and returns the file path.
+func open(t *testing.T, s accesslog.Settings) (*accesslog.Logger, string) {
+	s.Path = filepath.Join(t.TempDir(), "access.log")
+	l := accesslog.NewLogger()
+	if err := l.Configure(&s); err != nil {
+		t.Fatal("Expected nil error got ", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l, s.Path
+}
+
+func read(t *testing.T, path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal("Expected nil error got ", err)
+	}
+	return string(data)
+}
+
+func TestLogger_Formats(t *testing.T) {
+	tests := []struct {
+		settings accesslog.Settings
+		expected string
+	}{
+		{accesslog.Settings{Format: accesslog.COMMON},
+			`127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET /testSvc01/testKey01/index.html?q=1 HTTP/1.1" 200 2326 testSvc01/testKey01 localhost:8080 1500ms 3f2a` + "\n"},
+		{accesslog.Settings{},
+			`127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET /testSvc01/testKey01/index.html?q=1 HTTP/1.1" 200 2326 "-" "test-agent" testSvc01/testKey01 localhost:8080 1500ms 3f2a` + "\n"},
+		{accesslog.Settings{Format: accesslog.TEMPLATE, Template: "{{.ClientIP}} {{.Service}}/{{.Version}} {{.Target}} {{.Status}} {{.Duration}}"},
+			"127.0.0.1 testSvc01/testKey01 localhost:8080 200 1.5s\n"},
+	}
+	for _, test := range tests {
+		l, path := open(t, test.settings)
+		l.Log(entry)
+		if out := read(t, path); out != test.expected {
+			t.Errorf("Expected %q got %q", test.expected, out)
+		}
+	}
+
+	l, path := open(t, accesslog.Settings{Format: accesslog.JSON})
+	l.Log(entry)
+	l.Log(entry)
+	lines := strings.Split(strings.TrimSuffix(read(t, path), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatal("Expected 2 lines got ", lines)
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &decoded); err != nil {
+		t.Fatal("Expected nil error got ", err)
+	}
+	if decoded["Target"] != "localhost:8080" || decoded["Status"] != 200.0 || decoded["Bytes"] != 2326.0 || decoded["DurationMs"] != 1500.0 || decoded["RequestID"] != "3f2a" {
+		t.Error("Expected entry fields got ", decoded)
+	}
+}
+
+func TestLogger_Rotate(t *testing.T) {
+	l, path := open(t, accesslog.Settings{Format: accesslog.COMMON, MaxSizeMB: 1, MaxBackups: 2})
+	for i := 0; i < (4<<20)/100; i++ {
+		l.Log(entry)
+	}
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatal("Expected file got ", err)
+		}
+		if info.Size() > 1<<20 {
+			t.Error("Expected file no larger than 1MB got ", info.Size())
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Error("Expected at most 2 backups got ", err)
+	}
+}
+
+func TestLogger_RotateFailure(t *testing.T) {
+	l, path := open(t, accesslog.Settings{Format: accesslog.COMMON, MaxSizeMB: 1, MaxBackups: 1})
+	//A non-empty directory in place of the backup cannot be removed, so rotation fails.
+	if err := os.MkdirAll(filepath.Join(path+".1", "keep"), 0755); err != nil {
+		t.Fatal("Could not build backup got ", err)
+	}
+	n := (2 << 20) / 100
+	for i := 0; i < n; i++ {
+		l.Log(entry)
+	}
+	if lines := strings.Count(read(t, path), "\n"); lines != n {
+		t.Error("Expected all ", n, " entries in the current file after failed rotation got ", lines)
+	}
+}
+
+func TestLogger_Configure(t *testing.T) {
+	var l *accesslog.Logger
+	l.Log(entry)
+
+	l = accesslog.NewLogger()
+	if err := l.Configure(&accesslog.Settings{Format: "xml"}); err != accesslog.ErrUnknownFormat {
+		t.Error("Expected ErrUnknownFormat got ", err)
+	}
+	if err := l.Configure(&accesslog.Settings{Format: accesslog.TEMPLATE}); err != accesslog.ErrNoTemplate {
+		t.Error("Expected ErrNoTemplate got ", err)
+	}
+	if err := (accesslog.Settings{Format: accesslog.TEMPLATE, Template: "{{.Missing"}).Validate(); err == nil {
+		t.Error("Expected template parse error got nil")
+	}
+
+	l, path := open(t, accesslog.Settings{Format: accesslog.COMMON})
+	if err := l.Configure(nil); err != nil {
+		t.Fatal("Expected nil error got ", err)
+	}
+	l.Log(entry)
+	if out := read(t, path); out != "" {
+		t.Error("Expected disabled logger to write nothing got ", out)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	l, path := open(t, accesslog.Settings{Format: accesslog.COMMON})
+	if _, err := accesslog.Open(&accesslog.Settings{Path: filepath.Join(t.TempDir(), "missing", "access.log")}); err == nil {
+		t.Error("Expected error opening log in missing directory got nil")
+	}
+	o, err := accesslog.Open(&accesslog.Settings{Format: accesslog.JSON, Path: path})
+	if err != nil {
+		t.Fatal("Expected nil error got ", err)
+	}
+	l.Log(entry)
+	if out := read(t, path); strings.HasPrefix(out, "{") {
+		t.Error("Expected output not to apply before Apply got ", out)
+	}
+	l.Apply(o)
+	l.Log(entry)
+	if lines := strings.Split(strings.TrimSpace(read(t, path)), "\n"); len(lines) != 2 || !strings.HasPrefix(lines[1], "{") {
+		t.Error("Expected JSON line after Apply got ", lines)
+	}
+	disabled, _ := accesslog.Open(nil)
+	l.Apply(disabled)
+	if err := o.Close(); !errors.Is(err, os.ErrClosed) {
+		t.Error("Expected replaced output to be closed got ", err)
+	}
+}
@@ -0,0 +1,82 @@
+package accesslog
+
+import (
+	"fmt"
+	"os"
+)
+
+//Log file that is rotated once it reaches a maximum size. The current file is renamed to
+//path.1, shifting older files up to path.N, and a new file is started.
+type rotatingFile struct {
+	path       string   //Path of the current file.
+	maxSize    int64    //Size in bytes at which the file is rotated.
+	maxBackups int      //Rotated files kept.
+	file       *os.File //Current file.
+	size       int64    //Bytes in the current file.
+}
+
+func openRotatingFile(path string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+//Writes p rotating the file first if p would take it past the maximum size. Writes are not
+//split across files. If the file cannot be rotated p is still written to the current file and
+//the rotation error is returned; rotation is retried on the next write.
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	var rotateErr error
+	if r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		rotateErr = r.rotate()
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	if err == nil && rotateErr != nil {
+		err = fmt.Errorf("rotating %s: %w", r.path, rotateErr)
+	}
+	return n, err
+}
+
+func (r *rotatingFile) Close() error {
+	return r.file.Close()
+}
+
+func (r *rotatingFile) open() error {
+	file, err := os.OpenFile(r.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	r.file, r.size = file, info.Size()
+	return nil
+}
+
+//Shifts the backups and starts a new file. The current file stays open until the new one is,
+//so on error the file keeps being written; a backup that cannot be shifted stops the rotation
+//before the current file would replace it.
+func (r *rotatingFile) rotate() error {
+	if err := os.Remove(fmt.Sprintf("%s.%d", r.path, r.maxBackups)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for i := r.maxBackups - 1; i >= 1; i-- {
+		if err := os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(r.path, r.path+".1"); err != nil {
+		return err
+	}
+	previous := r.file
+	if err := r.open(); err != nil {
+		//The open file now is path.1; it is written until a rotation succeeds.
+		return err
+	}
+	previous.Close()
+	return nil
+}
@@ -0,0 +1,244 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cbergoon/glb/balancer"
+	"github.com/cbergoon/glb/breaker"
+	"github.com/cbergoon/glb/health"
+	"github.com/cbergoon/glb/outlier"
+	"github.com/cbergoon/glb/proxy"
+	"github.com/cbergoon/glb/registry"
+)
+
+const (
+	PREFIX         = "/registry"                                                                                //Path the API is served under.
+	RELOAD_WARNING = `299 glb "change is not saved to the configuration file and is undone by the next reload"` //Warning header of changes to a registry rebuilt on reload.
+)
+
+var (
+	ErrNotFound       = errors.New("admin: resource not found")
+	ErrMethod         = errors.New("admin: method not allowed")
+	ErrInvalidTarget  = errors.New("admin: target requires an address without \"/\" and a weight that is not negative")
+	ErrTargetExists   = errors.New("admin: target address already registered")
+	ErrTargetNotFound = errors.New("admin: target address not registered")
+)
+
+//JSON API managing the registry of a running load balancer. Services, versions and targets
+//are addressed by path:
+//
+//	GET    /registry                         lists services
+//	GET    /registry/{service}               lists versions of service
+//	GET    /registry/{service}/{version}     lists targets of service/version with stats
+//	POST   /registry/{service}/{version}     adds the target in the request body
+//	GET    /registry/{service}/{version}/{address}  shows the target with stats
+//	DELETE /registry/{service}/{version}/{address}  removes the target
+//
+//Unless the registry is persistent, changes are undone by the next reload; the responses to
+//them carry a Warning header saying so and the change is logged.
+type API struct {
+	lock       sync.Locker        //Serializes changes so an address is checked and added or removed atomically.
+	persistent bool               //Whether changes survive a reload.
+	reg        registry.Registry  //Registry managed by the API.
+	checker    *health.Checker    //Health of each target; may be nil.
+	balancers  *balancer.Selector //Connection and request counts of each target; may be nil.
+	breakers   *breaker.Breakers  //Circuit breaker state of each target; may be nil.
+	detector   *outlier.Detector  //Outlier ejection of each target; may be nil.
+}
+
+//State of a target as reported by the API.
+type TargetStats struct {
+	Address       string     //Host:port of the target.
+	Weight        int        //Relative share of traffic; zero is one.
+	Failures      int        //Consecutive failures recorded in the registry.
+	Healthy       bool       //Result of the active health check.
+	Breaker       string     //Circuit breaker state; closed, open or half-open.
+	Ejected       bool       //Ejected by the outlier detector.
+	Connections   int        //Open connections.
+	Requests      int        //In-flight requests.
+	LastError     string     //Most recent proxy or health check error; empty if none.
+	LastErrorTime *time.Time //Time of LastError; nil if none.
+}
+
+type errorResponse struct {
+	Error string
+}
+
+//Creates an API managing reg, which keeps its targets across reloads if persistent is true. The
+//checker, balancers, breakers and detector provide per-target stats and may be nil. Changes are
+//made holding lock; passing the lock held by reloads keeps a change from being made to a
+//registry a reload is replacing. A nil lock serializes changes with each other only.
+func NewAPI(reg registry.Registry, checker *health.Checker, balancers *balancer.Selector, breakers *breaker.Breakers, detector *outlier.Detector, persistent bool, lock sync.Locker) *API {
+	if lock == nil {
+		lock = &sync.Mutex{}
+	}
+	return &API{reg: reg, checker: checker, balancers: balancers, breakers: breakers, detector: detector, persistent: persistent, lock: lock}
+}
+
+func (a *API) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(req.URL.Path, PREFIX), "/")
+	var parts []string
+	if path != "" {
+		parts = strings.Split(path, "/")
+	}
+	switch {
+	case len(parts) == 0 && req.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, a.reg.ServiceNames())
+	case len(parts) == 1 && req.Method == http.MethodGet:
+		keys, err := a.reg.KeyNames(parts[0])
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, keys)
+	case len(parts) == 2 && req.Method == http.MethodGet:
+		targets, err := a.reg.Lookup(parts[0], parts[1])
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		stats := make([]TargetStats, len(targets))
+		for i := range targets {
+			stats[i] = a.stats(parts[0], parts[1], targets[i])
+		}
+		writeJSON(w, http.StatusOK, stats)
+	case len(parts) == 2 && req.Method == http.MethodPost:
+		a.add(w, req, parts[0], parts[1])
+	case len(parts) == 3 && req.Method == http.MethodGet:
+		t, err := a.find(parts[0], parts[1], parts[2])
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, a.stats(parts[0], parts[1], t))
+	case len(parts) == 3 && req.Method == http.MethodDelete:
+		a.lock.Lock()
+		defer a.lock.Unlock()
+		if _, err := a.find(parts[0], parts[1], parts[2]); err != nil {
+			writeError(w, err)
+			return
+		}
+		a.reg.Delete(parts[0], parts[1], registry.Target{Address: parts[2]})
+		a.warn(w, "removed", parts[0], parts[1], parts[2])
+		w.WriteHeader(http.StatusNoContent)
+	case len(parts) <= 3:
+		writeError(w, ErrMethod)
+	default:
+		writeError(w, ErrNotFound)
+	}
+}
+
+//Decodes the target in the request body and adds it to service/version. Returns
+//ErrInvalidTarget if the target is invalid and ErrTargetExists if the address is already
+//registered for service/version.
+func (a *API) add(w http.ResponseWriter, req *http.Request, svcValue string, keyValue string) {
+	var t registry.Target
+	if err := json.NewDecoder(req.Body).Decode(&t); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "admin: invalid target: " + err.Error()})
+		return
+	}
+	if err := t.Validate(); err != nil {
+		writeError(w, ErrInvalidTarget)
+		return
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if _, err := a.find(svcValue, keyValue, t.Address); err == nil {
+		writeError(w, ErrTargetExists)
+		return
+	} else if err != ErrTargetNotFound && err != registry.ErrServiceNotFound {
+		writeError(w, err)
+		return
+	}
+	t.Failures = 0
+	a.reg.Add(svcValue, keyValue, t)
+	t, err := a.find(svcValue, keyValue, t.Address)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	a.warn(w, "added", svcValue, keyValue, t.Address)
+	writeJSON(w, http.StatusCreated, a.stats(svcValue, keyValue, t))
+}
+
+//Returns the target of service/version with address. Returns ErrTargetNotFound if the
+//service/version exists but the address is not registered.
+func (a *API) find(svcValue string, keyValue string, address string) (registry.Target, error) {
+	targets, err := a.reg.Lookup(svcValue, keyValue)
+	if err != nil {
+		return registry.Target{}, err
+	}
+	for i := range targets {
+		if targets[i].Address == address {
+			return targets[i], nil
+		}
+	}
+	return registry.Target{}, ErrTargetNotFound
+}
+
+//Logs a change to the registry and, unless the registry is persistent, warns the client the
+//change is undone by the next reload.
+func (a *API) warn(w http.ResponseWriter, change string, svcValue string, keyValue string, address string) {
+	if a.persistent {
+		log.Printf("admin: %s target %s of %s/%s", change, address, svcValue, keyValue)
+		return
+	}
+	log.Printf("admin: %s target %s of %s/%s until the next reload", change, address, svcValue, keyValue)
+	w.Header().Set("Warning", RELOAD_WARNING)
+}
+
+func (a *API) stats(svcValue string, keyValue string, t registry.Target) TargetStats {
+	stats := TargetStats{
+		Address:  t.Address,
+		Weight:   t.Weight,
+		Failures: t.Failures,
+		Healthy:  a.checker.Healthy(svcValue, keyValue, t.Address),
+		Breaker:  a.breakers.State(svcValue, keyValue, t.Address),
+		Ejected:  !a.detector.Available(svcValue, keyValue, t.Address),
+	}
+	message, at := a.checker.LastError(svcValue, keyValue, t.Address)
+	if a.balancers != nil {
+		stats.Connections = a.balancers.Connections.Count(svcValue, keyValue, t.Address)
+		stats.Requests = a.balancers.Requests.Count(svcValue, keyValue, t.Address)
+		if e, ok := a.balancers.Errors.Get(svcValue, keyValue, t.Address); ok && e.Time.After(at) {
+			message, at = e.Error, e.Time
+		}
+	}
+	if message != "" {
+		stats.LastError, stats.LastErrorTime = message, &at
+	}
+	return stats
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+//Writes err as a JSON error response with the status matching the error.
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, ErrNotFound), errors.Is(err, ErrTargetNotFound), errors.Is(err, registry.ErrServiceNotFound),
+		errors.Is(err, proxy.ErrNoRoute), errors.Is(err, proxy.ErrInvalidPath), errors.Is(err, proxy.ErrSplitNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrMethod):
+		status = http.StatusMethodNotAllowed
+	case errors.Is(err, ErrInvalidTarget), errors.Is(err, ErrInvalidSample):
+		status = http.StatusBadRequest
+	case errors.Is(err, ErrTargetExists):
+		status = http.StatusConflict
+	case errors.Is(err, ErrUnauthorized):
+		status = http.StatusUnauthorized
+	case errors.Is(err, ErrForbidden):
+		status = http.StatusForbidden
+	}
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
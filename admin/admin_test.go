@@ -0,0 +1,348 @@
+package admin_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"errors"
+	"github.com/cbergoon/glb/admin"
+	"github.com/cbergoon/glb/balancer"
+	"github.com/cbergoon/glb/proxy"
+	"github.com/cbergoon/glb/registry"
+	"github.com/cbergoon/glb/registry/standardregistry"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func request(t *testing.T, method string, url string, body string, v interface{}) int {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestAPI_Lock(t *testing.T) {
+	var lock sync.Mutex
+	sr := &serviceregistry.StandardRegistry{}
+	s := httptest.NewServer(admin.NewAPI(sr, nil, nil, nil, nil, false, &lock))
+	defer s.Close()
+
+	lock.Lock()
+	done := make(chan *http.Response)
+	go func() {
+		resp, _ := http.Post(s.URL+admin.PREFIX+"/testSvc01/testKey01", "application/json", strings.NewReader(`{"Address": "localhost:9090"}`))
+		done <- resp
+	}()
+	select {
+	case <-done:
+		t.Error("Expected change to wait for the lock")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if _, err := sr.Lookup("testSvc01", "testKey01"); err != registry.ErrServiceNotFound {
+		t.Error("Expected no change while the lock is held got ", err)
+	}
+	lock.Unlock()
+	resp := <-done
+	if resp == nil || resp.StatusCode != http.StatusCreated {
+		t.Fatal("Expected 201 once the lock is released got ", resp)
+	}
+	resp.Body.Close()
+}
+
+func TestAPI(t *testing.T) {
+	sr := &serviceregistry.StandardRegistry{}
+	sr.Add("testSvc01", "testKey01", registry.Target{Address: "localhost:8080"})
+	sr.Add("testSvc01", "testKey02", registry.Target{Address: "localhost:8081"})
+	s := httptest.NewServer(admin.NewAPI(sr, nil, nil, nil, nil, false, nil))
+	defer s.Close()
+	url := s.URL + admin.PREFIX
+
+	var names []string
+	if status := request(t, "GET", url, "", &names); status != http.StatusOK || len(names) != 1 || names[0] != "testSvc01" {
+		t.Error("Expected services [testSvc01] got ", status, names)
+	}
+	if status := request(t, "GET", url+"/testSvc01", "", &names); status != http.StatusOK || len(names) != 2 {
+		t.Error("Expected two versions got ", status, names)
+	}
+	if status := request(t, "GET", url+"/noExist", "", nil); status != http.StatusNotFound {
+		t.Error("Expected 404 for unknown service got ", status)
+	}
+
+	var stats admin.TargetStats
+	if status := request(t, "POST", url+"/testSvc02/testKey01", `{"Address": "localhost:9090", "Weight": 2}`, &stats); status != http.StatusCreated {
+		t.Error("Expected 201 got ", status)
+	}
+	if stats.Address != "localhost:9090" || stats.Weight != 2 || !stats.Healthy || stats.Breaker != "closed" {
+		t.Error("Expected added target stats got ", stats)
+	}
+	if status := request(t, "POST", url+"/testSvc02/testKey01", `{"Address": "localhost:9090"}`, nil); status != http.StatusConflict {
+		t.Error("Expected 409 for duplicate target got ", status)
+	}
+	if status := request(t, "POST", url+"/testSvc02/testKey01", `{"Weight": 1}`, nil); status != http.StatusBadRequest {
+		t.Error("Expected 400 for target without address got ", status)
+	}
+	if status := request(t, "POST", url+"/testSvc02/testKey01", `{"Address": "localhost:9091", "Weight": -1}`, nil); status != http.StatusBadRequest {
+		t.Error("Expected 400 for target with negative weight got ", status)
+	}
+	resp, err := http.Post(url+"/testSvc02/testKey02", "application/json", strings.NewReader(`{"Address": "localhost:9092"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated || resp.Header.Get("Warning") != admin.RELOAD_WARNING {
+		t.Error("Expected reload warning on added target got ", resp.StatusCode, resp.Header.Get("Warning"))
+	}
+	targets, err := sr.Lookup("testSvc02", "testKey01")
+	if err != nil || len(targets) != 1 {
+		t.Error("Expected target in registry got ", targets, err)
+	}
+
+	sr.IncrementFailures("testSvc01", "testKey01", registry.Target{Address: "localhost:8080"}, 2)
+	var list []admin.TargetStats
+	if status := request(t, "GET", url+"/testSvc01/testKey01", "", &list); status != http.StatusOK || len(list) != 1 || list[0].Failures != 2 {
+		t.Error("Expected target with two failures got ", status, list)
+	}
+	if status := request(t, "GET", url+"/testSvc01/testKey01/localhost:8080", "", &stats); status != http.StatusOK || stats.Address != "localhost:8080" {
+		t.Error("Expected target stats got ", status, stats)
+	}
+
+	if status := request(t, "DELETE", url+"/testSvc01/testKey01/localhost:8080", "", nil); status != http.StatusNoContent {
+		t.Error("Expected 204 got ", status)
+	}
+	if status := request(t, "DELETE", url+"/testSvc01/testKey01/localhost:8080", "", nil); status != http.StatusNotFound {
+		t.Error("Expected 404 for removed target got ", status)
+	}
+	if status := request(t, "PUT", url+"/testSvc01/testKey01", "", nil); status != http.StatusMethodNotAllowed {
+		t.Error("Expected 405 got ", status)
+	}
+}
+
+func TestAuthenticator(t *testing.T) {
+	auth := admin.NewAuthenticator()
+	ok := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})
+	serve := func(h http.Handler, method string, setup func(req *http.Request)) int {
+		req := httptest.NewRequest(method, "/registry", nil)
+		if setup != nil {
+			setup(req)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if status := serve(auth.Protect(ok), "POST", nil); status != http.StatusOK {
+		t.Error("Expected open access without credentials got ", status)
+	}
+
+	err := auth.Configure(admin.Settings{Credentials: []admin.Credential{{Role: "admin", Token: "secret"}}})
+	if err != admin.ErrInvalidRole {
+		t.Error("Expected ErrInvalidRole got ", err)
+	}
+	err = auth.Configure(admin.Settings{Credentials: []admin.Credential{{Role: admin.READ_ONLY}}})
+	if err != admin.ErrInvalidCredential {
+		t.Error("Expected ErrInvalidCredential got ", err)
+	}
+	for _, c := range []admin.Credential{
+		{Role: admin.READ_WRITE, Username: "deploy"},
+		{Role: admin.READ_WRITE, Password: "secret"},
+		{Role: admin.READ_WRITE, Token: "secret", CommonName: "deploy"},
+		{Role: admin.READ_WRITE, Token: "secret", Username: "deploy", Password: "secret"},
+	} {
+		if err := auth.Configure(admin.Settings{Credentials: []admin.Credential{c}}); err != admin.ErrInvalidCredential {
+			t.Error("Expected ErrInvalidCredential for ", c, " got ", err)
+		}
+	}
+	for _, s := range []admin.Settings{{CertFile: "admin.crt"}, {KeyFile: "admin.key"}, {ClientCAFile: "ca.pem"}} {
+		if err := s.Validate(); err != admin.ErrInvalidTLS {
+			t.Error("Expected ErrInvalidTLS for ", s, " got ", err)
+		}
+	}
+	err = auth.Configure(admin.Settings{Credentials: []admin.Credential{
+		{Role: admin.READ_ONLY, Token: "monitor-token"},
+		{Role: admin.READ_WRITE, Username: "deploy", Password: "secret"},
+		{Role: admin.READ_ONLY, CommonName: "monitor"},
+	}})
+	if err != nil {
+		t.Fatal("Expected nil error got ", err)
+	}
+
+	bearer := func(req *http.Request) { req.Header.Set("Authorization", "Bearer monitor-token") }
+	basic := func(req *http.Request) { req.SetBasicAuth("deploy", "secret") }
+	wrong := func(req *http.Request) { req.SetBasicAuth("deploy", "wrong") }
+	cert := func(req *http.Request) {
+		req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: "monitor"}}}}}
+	}
+	cases := []struct {
+		handler http.Handler
+		method  string
+		setup   func(req *http.Request)
+		status  int
+	}{
+		{auth.Protect(ok), "GET", nil, http.StatusUnauthorized},
+		{auth.Protect(ok), "GET", wrong, http.StatusUnauthorized},
+		{auth.Protect(ok), "GET", bearer, http.StatusOK},
+		{auth.Protect(ok), "DELETE", bearer, http.StatusForbidden},
+		{auth.Protect(ok), "GET", cert, http.StatusOK},
+		{auth.Protect(ok), "POST", cert, http.StatusForbidden},
+		{auth.Protect(ok), "POST", basic, http.StatusOK},
+		{auth.ProtectWrite(ok), "GET", bearer, http.StatusForbidden},
+		{auth.ProtectWrite(ok), "GET", basic, http.StatusOK},
+	}
+	for i, c := range cases {
+		if status := serve(c.handler, c.method, c.setup); status != c.status {
+			t.Error("Case ", i, ": expected status ", c.status, " got ", status)
+		}
+	}
+
+	s := httptest.NewServer(auth.Protect(admin.NewAPI(&serviceregistry.StandardRegistry{}, nil, nil, nil, nil, false, nil)))
+	defer s.Close()
+	u, _ := url.Parse(s.URL + admin.PREFIX)
+	u.User = url.UserPassword("deploy", "secret")
+	if status := request(t, "POST", u.String()+"/testSvc01/testKey01", `{"Address": "localhost:8080"}`, nil); status != http.StatusCreated {
+		t.Error("Expected read-write credential to add target got ", status)
+	}
+}
+
+func TestAPI_Status(t *testing.T) {
+	sr := &serviceregistry.StandardRegistry{}
+	sr.Add("testSvc01", "testKey01", registry.Target{Address: "localhost:8080", Weight: 2})
+	sr.Add("testSvc01", "testKey01", registry.Target{Address: "localhost:8081"})
+	sr.Add("testSvc02", "testKey01", registry.Target{Address: "localhost:8082"})
+	sr.SetRoundRobbinCounter("testSvc01", "testKey01", 1)
+	balancers := balancer.NewSelector(sr)
+	balancers.Configure(map[string]map[string]balancer.Settings{"testSvc02": {"testKey01": {Name: balancer.LEAST_CONNECTIONS}}})
+	balancers.Errors.Record("testSvc01", "testKey01", "localhost:8081", errors.New("connection refused"))
+	api := admin.NewAPI(sr, nil, balancers, nil, nil, false, nil)
+
+	s := httptest.NewServer(http.HandlerFunc(api.ServeStatus))
+	defer s.Close()
+	var status admin.Status
+	if code := request(t, "GET", s.URL, "", &status); code != http.StatusOK {
+		t.Fatal("Expected 200 got ", code)
+	}
+	if status.Version != admin.STATUS_VERSION || len(status.Services) != 2 {
+		t.Fatal("Expected two services in status got ", status)
+	}
+	v := status.Services[0].Versions[0]
+	if status.Services[0].Name != "testSvc01" || v.Name != "testKey01" || v.Balancer != balancer.ROUND_ROBIN || v.RoundRobinPosition != 1 {
+		t.Error("Expected testSvc01/testKey01 by round robin at position 1 got ", status.Services[0])
+	}
+	if len(v.Targets) != 2 || v.Targets[0].Weight != 2 || v.Targets[0].LastErrorTime != nil {
+		t.Error("Expected weighted target without error got ", v.Targets)
+	}
+	if v.Targets[1].LastError != "connection refused" || v.Targets[1].LastErrorTime == nil {
+		t.Error("Expected last error of target got ", v.Targets[1])
+	}
+	if b := status.Services[1].Versions[0].Balancer; b != balancer.LEAST_CONNECTIONS {
+		t.Error("Expected leastconn balancer got ", b)
+	}
+
+	rec := httptest.NewRecorder()
+	api.ServeDashboard(rec, httptest.NewRequest("GET", "/dashboard", nil))
+	if !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") || !strings.Contains(rec.Body.String(), "connection refused") {
+		t.Error("Expected HTML dashboard listing the last error got ", rec.Body.String())
+	}
+}
+
+func TestRouteTester(t *testing.T) {
+	sr := &serviceregistry.StandardRegistry{}
+	sr.Add("s1", "v1", registry.Target{Address: "localhost:8080"})
+	router := proxy.NewRouter(sr)
+	table, err := proxy.CompileRouting(nil, map[string]proxy.Destination{"orders.internal": {Service: "orders", Version: "v1"}}, []proxy.Route{
+		{Name: "api", PathPrefix: "/api/", Methods: []string{"POST"}, Service: "api", Version: "v2", Rewrite: "/"},
+		{PathRegex: "^/legacy/(.*)$", ClientCIDRs: []string{"10.0.0.0/8"}, Service: "legacy", Version: "v1", Rewrite: "/v1/$1"},
+	}, nil, nil)
+	if err != nil {
+		t.Fatal("Expected nil error got ", err)
+	}
+	router.Apply(table)
+	var basic atomic.Bool
+	s := httptest.NewServer(admin.NewRouteTester(router, &basic))
+	defer s.Close()
+	url := s.URL + admin.ROUTES_PREFIX
+
+	var routes []proxy.Route
+	if status := request(t, "GET", url, "", &routes); status != http.StatusOK || len(routes) != 2 || routes[1].Name != "1" {
+		t.Error("Expected route table got ", status, routes)
+	}
+	tests := []struct {
+		sample   string
+		expected proxy.Resolution
+	}{
+		{`{"Method": "post", "URL": "/api/orders?id=1"}`, proxy.Resolution{Source: "route", Route: "api", Service: "api", Version: "v2", Path: "/orders"}},
+		{`{"URL": "/legacy/a/b", "ClientIP": "10.1.2.3"}`, proxy.Resolution{Source: "route", Route: "1", Service: "legacy", Version: "v1", Path: "/v1/a/b"}},
+		{`{"URL": "/legacy/a/b", "Host": "orders.internal"}`, proxy.Resolution{Source: "host", Service: "orders", Version: "v1", Path: "/legacy/a/b"}},
+		{`{"URL": "/s1/v1/a"}`, proxy.Resolution{Source: "path", Service: "s1", Version: "v1", Path: "/a"}},
+	}
+	for _, test := range tests {
+		var resolution proxy.Resolution
+		if status := request(t, "POST", url+"/test", test.sample, &resolution); status != http.StatusOK || resolution != test.expected {
+			t.Error("Expected ", test.expected, " for ", test.sample, " got ", status, resolution)
+		}
+	}
+	if status := request(t, "POST", url+"/test", `{"URL": "/index.html"}`, nil); status != http.StatusNotFound {
+		t.Error("Expected 404 for unroutable request got ", status)
+	}
+	if status := request(t, "POST", url+"/test", `{"URL": "http//bad"}`, nil); status != http.StatusBadRequest {
+		t.Error("Expected 400 for invalid sample got ", status)
+	}
+	basic.Store(true)
+	var resolution proxy.Resolution
+	if status := request(t, "POST", url+"/test", `{"URL": "/"}`, &resolution); status != http.StatusOK || resolution.Source != "basic" {
+		t.Error("Expected basic mode resolution got ", status, resolution)
+	}
+}
+
+func TestSplitEditor(t *testing.T) {
+	sr := &serviceregistry.StandardRegistry{}
+	sr.Add("s1", "v1", registry.Target{Address: "localhost:8080"})
+	sr.Add("s1", "v2", registry.Target{Address: "localhost:8081"})
+	router := proxy.NewRouter(sr)
+	s := httptest.NewServer(admin.NewSplitEditor(router))
+	defer s.Close()
+	url := s.URL + admin.SPLITS_PREFIX
+
+	var split proxy.Split
+	if status := request(t, "PUT", url+"/s1/v1", `{"Weights": {"v1": 95, "v2": 5}}`, &split); status != http.StatusOK || split.Weights["v2"] != 5 || split.Header != proxy.PIN_HEADER {
+		t.Error("Expected split to be set got ", status, split)
+	}
+	if status := request(t, "PUT", url+"/s1/v1", `{"Weights": {"v1": -1}}`, nil); status != http.StatusBadRequest {
+		t.Error("Expected 400 for invalid split got ", status)
+	}
+	if status := request(t, "PUT", url+"/s1/v1", `{"Weights": {"v1": 95, "v3": 5}}`, nil); status != http.StatusBadRequest {
+		t.Error("Expected 400 for version outside the registry got ", status)
+	}
+	var splits map[string]map[string]proxy.Split
+	if status := request(t, "GET", url, "", &splits); status != http.StatusOK || splits["s1"]["v1"].Weights["v1"] != 95 {
+		t.Error("Expected splits got ", status, splits)
+	}
+	if m, err := router.Match(httptest.NewRequest("GET", "/s1/v1/a", nil), false); err != nil || m.Split != "v1" {
+		t.Error("Expected split to apply to routing got ", m, err)
+	}
+	if status := request(t, "DELETE", url+"/s1/v1", "", nil); status != http.StatusNoContent {
+		t.Error("Expected 204 got ", status)
+	}
+	if status := request(t, "GET", url+"/s1/v1", "", nil); status != http.StatusNotFound {
+		t.Error("Expected 404 for removed split got ", status)
+	}
+	if status := request(t, "POST", url+"/s1/v1", "{}", nil); status != http.StatusMethodNotAllowed {
+		t.Error("Expected 405 got ", status)
+	}
+}
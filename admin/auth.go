@@ -0,0 +1,232 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+const (
+	READ_ONLY  = "read-only"  //May read status, stats and the registry.
+	READ_WRITE = "read-write" //May additionally reload the configuration and change the registry.
+)
+
+var (
+	ErrUnauthorized      = errors.New("admin: authentication required")
+	ErrForbidden         = errors.New("admin: role does not permit this request")
+	ErrInvalidRole       = errors.New("admin: role must be read-only or read-write")
+	ErrInvalidCredential = errors.New("admin: credential requires exactly one of a token, a username and password or a common name")
+	ErrInvalidClientCA   = errors.New("admin: no certificates found in client CA file")
+	ErrInvalidTLS        = errors.New("admin: TLS requires both a certificate and key file and a client CA file requires both")
+)
+
+type Settings struct {
+	Credentials  []Credential //Clients allowed to use the management endpoints; open to all if empty.
+	CertFile     string       //Certificate served by the admin listener; plain HTTP if blank.
+	KeyFile      string       //Key of CertFile.
+	ClientCAFile string       //PEM bundle verifying client certificates; requires CertFile.
+}
+
+//A client of the management endpoints identified by exactly one of a bearer token, HTTP basic
+//username and password or, the common name of a verified client certificate.
+type Credential struct {
+	Role       string //read-only or read-write.
+	Token      string //Bearer token.
+	Username   string //HTTP basic username.
+	Password   string //HTTP basic password.
+	CommonName string //Subject common name of a client certificate.
+}
+
+type Authenticator struct {
+	lock        sync.RWMutex //Exclusive lock for credentials.
+	credentials []Credential //Credentials accepted by the authenticator.
+}
+
+//Creates an authenticator that accepts every request until credentials are provided with
+//Configure.
+func NewAuthenticator() *Authenticator {
+	return &Authenticator{}
+}
+
+//Reports whether the credentials and TLS files can be applied. Returns ErrInvalidRole or
+//ErrInvalidCredential if any credential is invalid and, ErrInvalidTLS if only one of CertFile
+//and KeyFile is set or ClientCAFile is set without them.
+func (s Settings) Validate() error {
+	for _, c := range s.Credentials {
+		if c.Role != READ_ONLY && c.Role != READ_WRITE {
+			return ErrInvalidRole
+		}
+		identities := 0
+		if c.Token != "" {
+			identities++
+		}
+		if c.Username != "" || c.Password != "" {
+			if c.Username == "" || c.Password == "" {
+				return ErrInvalidCredential
+			}
+			identities++
+		}
+		if c.CommonName != "" {
+			identities++
+		}
+		if identities != 1 {
+			return ErrInvalidCredential
+		}
+	}
+	if (s.CertFile == "") != (s.KeyFile == "") || (s.ClientCAFile != "" && s.CertFile == "") {
+		return ErrInvalidTLS
+	}
+	return nil
+}
+
+//Credentials validated by CompileCredentials and applied at once by Apply.
+type CredentialSet struct {
+	credentials []Credential
+}
+
+//Replaces the accepted credentials. Returns ErrInvalidRole or ErrInvalidCredential and keeps
+//the current credentials if any credential is invalid.
+func (a *Authenticator) Configure(s Settings) error {
+	c, err := CompileCredentials(s)
+	if err != nil {
+		return err
+	}
+	a.Apply(c)
+	return nil
+}
+
+//Validates the settings as Configure does and copies their credentials without applying them.
+func CompileCredentials(s Settings) (*CredentialSet, error) {
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	return &CredentialSet{credentials: append([]Credential(nil), s.Credentials...)}, nil
+}
+
+//Replaces the accepted credentials with the set.
+func (a *Authenticator) Apply(c *CredentialSet) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.credentials = c.credentials
+	if len(a.credentials) == 0 {
+		log.Print("admin: no credentials configured; management endpoints are not authenticated")
+	}
+}
+
+//Wraps h requiring a read-only credential for GET, HEAD and OPTIONS requests and a read-write
+//credential for any other method.
+func (a *Authenticator) Protect(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		role := READ_WRITE
+		switch req.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			role = READ_ONLY
+		}
+		a.serve(w, req, role, h)
+	})
+}
+
+//Wraps h requiring a read-write credential regardless of method. Used for endpoints that
+//change state on GET.
+func (a *Authenticator) ProtectWrite(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		a.serve(w, req, READ_WRITE, h)
+	})
+}
+
+//Wraps h requiring a read-only credential regardless of method. Used for endpoints that only
+//read state on POST.
+func (a *Authenticator) ProtectRead(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		a.serve(w, req, READ_ONLY, h)
+	})
+}
+
+func (a *Authenticator) serve(w http.ResponseWriter, req *http.Request, role string, h http.Handler) {
+	granted, ok := a.authenticate(req)
+	if !ok {
+		w.Header().Add("WWW-Authenticate", `Basic realm="glb"`)
+		w.Header().Add("WWW-Authenticate", `Bearer realm="glb"`)
+		writeError(w, ErrUnauthorized)
+		return
+	}
+	if role == READ_WRITE && granted != READ_WRITE {
+		writeError(w, ErrForbidden)
+		return
+	}
+	h.ServeHTTP(w, req)
+}
+
+//Returns the role of the credential presented by the request and whether one was accepted.
+//A verified client certificate takes precedence over the Authorization header. Every request
+//is granted read-write if no credentials are configured.
+func (a *Authenticator) authenticate(req *http.Request) (string, bool) {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	if len(a.credentials) == 0 {
+		return READ_WRITE, true
+	}
+	if req.TLS != nil && len(req.TLS.VerifiedChains) > 0 && len(req.TLS.VerifiedChains[0]) > 0 {
+		cn := req.TLS.VerifiedChains[0][0].Subject.CommonName
+		for _, c := range a.credentials {
+			if c.CommonName != "" && c.CommonName == cn {
+				return c.Role, true
+			}
+		}
+	}
+	header := req.Header.Get("Authorization")
+	if strings.HasPrefix(header, "Bearer ") {
+		token := strings.TrimPrefix(header, "Bearer ")
+		for _, c := range a.credentials {
+			if c.Token != "" && equal(c.Token, token) {
+				return c.Role, true
+			}
+		}
+	}
+	if username, password, ok := req.BasicAuth(); ok {
+		for _, c := range a.credentials {
+			if c.Username != "" && c.Password != "" && equal(c.Username, username) && equal(c.Password, password) {
+				return c.Role, true
+			}
+		}
+	}
+	return "", false
+}
+
+//Builds the TLS configuration of the admin listener. Client certificates are requested and
+//verified against ClientCAFile when it is set; clients without a certificate may still
+//authenticate with a token or password. Returns nil if CertFile is blank.
+func (s Settings) TLSConfig() (*tls.Config, error) {
+	if s.CertFile == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(s.CertFile, s.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if s.ClientCAFile != "" {
+		data, err := ioutil.ReadFile(s.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, ErrInvalidClientCA
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return config, nil
+}
+
+//Compares secrets in constant time.
+func equal(a string, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
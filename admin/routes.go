@@ -0,0 +1,108 @@
+package admin
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+
+	"github.com/cbergoon/glb/proxy"
+)
+
+const ROUTES_PREFIX = "/routes" //Path the route table is served under.
+
+var ErrInvalidSample = errors.New("admin: sample request requires a JSON body with a URL path")
+
+//Request tested against the routing of the proxy.
+type SampleRequest struct {
+	Method     string            //Request method; defaults to GET.
+	URL        string            //Path and query, e.g. /api/orders?id=1.
+	Host       string            //Host header.
+	ServerName string            //TLS server name; the request is plain HTTP if blank.
+	Headers    map[string]string //Request headers.
+	ClientIP   string            //Address of the client; defaults to 127.0.0.1.
+}
+
+//JSON API showing how the proxy routes requests:
+//
+//	GET  /routes       lists the route table
+//	POST /routes/test  resolves the sample request in the body without forwarding it
+type RouteTester struct {
+	router *proxy.Router //Router of the proxy.
+	basic  *atomic.Bool  //Whether the proxy runs in basic mode.
+}
+
+//Creates a route tester for the router of a proxy running in basic mode if basic is true.
+func NewRouteTester(router *proxy.Router, basic *atomic.Bool) *RouteTester {
+	return &RouteTester{router: router, basic: basic}
+}
+
+func (rt *RouteTester) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch strings.TrimSuffix(req.URL.Path, "/") {
+	case ROUTES_PREFIX:
+		if req.Method != http.MethodGet {
+			writeError(w, ErrMethod)
+			return
+		}
+		writeJSON(w, http.StatusOK, rt.router.Routes())
+	case ROUTES_PREFIX + "/test":
+		if req.Method != http.MethodPost {
+			writeError(w, ErrMethod)
+			return
+		}
+		var sample SampleRequest
+		if err := json.NewDecoder(req.Body).Decode(&sample); err != nil {
+			writeError(w, ErrInvalidSample)
+			return
+		}
+		test, err := sample.request()
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		resolution, err := rt.router.Match(test, rt.basic.Load())
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, resolution)
+	default:
+		writeError(w, ErrNotFound)
+	}
+}
+
+//Builds the request described by the sample.
+func (s SampleRequest) request() (*http.Request, error) {
+	u, err := url.ParseRequestURI(s.URL)
+	if err != nil || !strings.HasPrefix(s.URL, "/") {
+		return nil, ErrInvalidSample
+	}
+	req := &http.Request{
+		Method:     strings.ToUpper(s.Method),
+		URL:        u,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Host:       s.Host,
+		RequestURI: s.URL,
+		RemoteAddr: net.JoinHostPort("127.0.0.1", "0"),
+	}
+	if req.Method == "" {
+		req.Method = http.MethodGet
+	}
+	for name, value := range s.Headers {
+		req.Header.Set(name, value)
+	}
+	if s.ClientIP != "" {
+		req.RemoteAddr = net.JoinHostPort(s.ClientIP, "0")
+	}
+	if s.ServerName != "" {
+		req.TLS = &tls.ConnectionState{ServerName: s.ServerName}
+	}
+	return req, nil
+}
@@ -0,0 +1,77 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/cbergoon/glb/proxy"
+)
+
+const SPLITS_PREFIX = "/splits" //Path the traffic splits are served under.
+
+//JSON API adjusting the traffic splits of a running proxy. Changes last until the configuration
+//is next reloaded:
+//
+//	GET    /splits                      lists splits by service then version
+//	GET    /splits/{service}/{version}  shows the split of service/version
+//	PUT    /splits/{service}/{version}  adds or replaces the split in the request body
+//	DELETE /splits/{service}/{version}  removes the split
+type SplitEditor struct {
+	router *proxy.Router //Router of the proxy.
+}
+
+//Creates a split editor for the router of a proxy.
+func NewSplitEditor(router *proxy.Router) *SplitEditor {
+	return &SplitEditor{router: router}
+}
+
+func (e *SplitEditor) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(req.URL.Path, SPLITS_PREFIX), "/")
+	var parts []string
+	if path != "" {
+		parts = strings.Split(path, "/")
+	}
+	switch {
+	case len(parts) == 0 && req.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, e.router.Splits())
+	case len(parts) == 2 && req.Method == http.MethodGet:
+		s, err := e.router.Split(parts[0], parts[1])
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, s)
+	case len(parts) == 2 && req.Method == http.MethodPut:
+		var s proxy.Split
+		if err := json.NewDecoder(req.Body).Decode(&s); err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: "admin: invalid split: " + err.Error()})
+			return
+		}
+		if err := e.router.SetSplit(parts[0], parts[1], s); err != nil {
+			if errors.Is(err, proxy.ErrInvalidSplit) {
+				writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+				return
+			}
+			writeError(w, err)
+			return
+		}
+		s, err := e.router.Split(parts[0], parts[1])
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, s)
+	case len(parts) == 2 && req.Method == http.MethodDelete:
+		if err := e.router.RemoveSplit(parts[0], parts[1]); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case len(parts) == 0 || len(parts) == 2:
+		writeError(w, ErrMethod)
+	default:
+		writeError(w, ErrNotFound)
+	}
+}
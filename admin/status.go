@@ -0,0 +1,101 @@
+package admin
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+)
+
+const STATUS_VERSION = 1 //Version of the status document; incremented on incompatible changes.
+
+//Runtime state of every service, version and target.
+type Status struct {
+	Version  int             //STATUS_VERSION of the document.
+	Time     time.Time       //Time the status was taken.
+	Services []ServiceStatus //Services sorted by name.
+}
+
+type ServiceStatus struct {
+	Name     string          //Service name.
+	Versions []VersionStatus //Versions sorted by name.
+}
+
+type VersionStatus struct {
+	Name               string        //Version name.
+	Balancer           string        //Load balancing strategy.
+	RoundRobinPosition int           //Round robbin counter of the registry.
+	Targets            []TargetStats //Targets in registry order.
+}
+
+//Returns the runtime state of every service, version and target in the registry.
+func (a *API) Status() Status {
+	status := Status{Version: STATUS_VERSION, Time: time.Now(), Services: []ServiceStatus{}}
+	for _, svc := range a.reg.ServiceNames() {
+		keys, err := a.reg.KeyNames(svc)
+		if err != nil {
+			continue
+		}
+		s := ServiceStatus{Name: svc, Versions: []VersionStatus{}}
+		for _, key := range keys {
+			targets, err := a.reg.Lookup(svc, key)
+			if err != nil {
+				continue
+			}
+			v := VersionStatus{Name: key, Balancer: "roundrobin", Targets: make([]TargetStats, len(targets))}
+			if a.balancers != nil {
+				v.Balancer = a.balancers.Name(svc, key)
+			}
+			v.RoundRobinPosition, _ = a.reg.GetRoundRobbinCounter(svc, key)
+			for i := range targets {
+				v.Targets[i] = a.stats(svc, key, targets[i])
+			}
+			s.Versions = append(s.Versions, v)
+		}
+		status.Services = append(status.Services, s)
+	}
+	return status
+}
+
+//Serves the status as a JSON document.
+func (a *API) ServeStatus(w http.ResponseWriter, req *http.Request) {
+	writeJSON(w, http.StatusOK, a.Status())
+}
+
+//Serves the status as an HTML dashboard that refreshes itself every five seconds.
+func (a *API) ServeDashboard(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := dashboard.Execute(w, a.Status()); err != nil {
+		log.Print("admin: rendering dashboard: ", err)
+	}
+}
+
+var dashboard = template.Must(template.New("dashboard").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="5">
+<title>glb status</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; }
+.down { background: #fdd; }
+</style>
+</head>
+<body>
+<h1>glb status</h1>
+<p>Taken {{.Time.Format "2006-01-02 15:04:05 MST"}}</p>
+{{range .Services}}{{$svc := .Name}}{{range .Versions}}
+<h2>{{$svc}}/{{.Name}}</h2>
+<p>Balancer {{.Balancer}}, round robin position {{.RoundRobinPosition}}</p>
+<table>
+<tr><th>Address</th><th>Weight</th><th>Healthy</th><th>Breaker</th><th>Ejected</th><th>Failures</th><th>In flight</th><th>Connections</th><th>Last error</th></tr>
+{{range .Targets}}<tr{{if or (not .Healthy) .Ejected (ne .Breaker "closed")}} class="down"{{end}}>
+<td>{{.Address}}</td><td>{{.Weight}}</td><td>{{.Healthy}}</td><td>{{.Breaker}}</td><td>{{.Ejected}}</td><td>{{.Failures}}</td><td>{{.Requests}}</td><td>{{.Connections}}</td>
+<td>{{if .LastErrorTime}}{{.LastErrorTime.Format "15:04:05"}} {{.LastError}}{{end}}</td></tr>
+{{end}}</table>
+{{end}}{{else}}<p>The registry is empty.</p>
+{{end}}</body>
+</html>
+`))
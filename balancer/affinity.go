@@ -0,0 +1,54 @@
+package balancer
+
+import (
+	"hash/fnv"
+	"net/http"
+	"strconv"
+
+	"github.com/cbergoon/glb/registry"
+)
+
+type Affinity struct {
+	Cookie        string //Cookie name; defaults to "glb-<service>-<version>".
+	MaxAgeSeconds int    //Cookie lifetime; zero means the cookie lasts for the browser session.
+}
+
+//Returns the index of the target encoded in the affinity cookie of the request or -1 if
+//the request has no cookie or the target is no longer among targets.
+func (a *Affinity) Pick(svcValue string, keyValue string, targets registry.OrderedTargets, req *http.Request) int {
+	c, err := req.Cookie(a.cookieName(svcValue, keyValue))
+	if err != nil {
+		return -1
+	}
+	for i := range targets {
+		if encodeTarget(targets[i].Address) == c.Value {
+			return i
+		}
+	}
+	return -1
+}
+
+//Returns the cookie binding the client to the target at address. The cookie value is an
+//opaque hash so target addresses are not exposed to clients.
+func (a *Affinity) SetCookie(svcValue string, keyValue string, address string) *http.Cookie {
+	return &http.Cookie{
+		Name:     a.cookieName(svcValue, keyValue),
+		Value:    encodeTarget(address),
+		Path:     "/",
+		MaxAge:   a.MaxAgeSeconds,
+		HttpOnly: true,
+	}
+}
+
+func (a *Affinity) cookieName(svcValue string, keyValue string) string {
+	if a.Cookie != "" {
+		return a.Cookie
+	}
+	return "glb-" + svcValue + "-" + keyValue
+}
+
+func encodeTarget(address string) string {
+	h := fnv.New64a()
+	h.Write([]byte(address))
+	return strconv.FormatUint(h.Sum64(), 36)
+}
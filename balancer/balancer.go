@@ -0,0 +1,47 @@
+package balancer
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/cbergoon/glb/registry"
+)
+
+var (
+	ErrUnknownBalancer = errors.New("balancer: unknown balancer; allowable balancers [roundrobin|random|leastconn|leastrequests|p2c|hash]")
+	ErrInvalidHashKey  = errors.New("balancer: invalid hash key; allowable sources [header|cookie|query|ip|path]")
+)
+
+const (
+	ROUND_ROBIN       = "roundrobin"    //Round robin; smooth weighted when targets carry weights.
+	RANDOM            = "random"        //Weighted random selection.
+	LEAST_CONNECTIONS = "leastconn"     //Fewest open connections relative to weight.
+	LEAST_REQUESTS    = "leastrequests" //Fewest in-flight requests relative to weight.
+	POWER_OF_TWO      = "p2c"           //Less loaded of two random choices.
+	CONSISTENT_HASH   = "hash"          //Consistent hash of a request key.
+)
+
+type Settings struct {
+	Name     string    //Balancer name; empty selects round robin.
+	HashKey  *HashKey  //Request key hashed by the consistent hash balancer.
+	Affinity *Affinity //Cookie based session affinity; disabled if nil.
+}
+
+//Reports whether the settings can be applied. Returns ErrUnknownBalancer if the name is not
+//known and ErrInvalidHashKey if a consistent hash balancer lacks a valid hash key.
+func (s Settings) Validate() error {
+	switch s.Name {
+	case "", ROUND_ROBIN, RANDOM, LEAST_CONNECTIONS, LEAST_REQUESTS, POWER_OF_TWO:
+	case CONSISTENT_HASH:
+		if s.HashKey == nil || !s.HashKey.valid() {
+			return ErrInvalidHashKey
+		}
+	default:
+		return ErrUnknownBalancer
+	}
+	return nil
+}
+
+type Balancer interface {
+	Select(svcValue string, keyValue string, targets registry.OrderedTargets, req *http.Request) int //Returns the index of the chosen target; targets is never empty. The request may be nil.
+}
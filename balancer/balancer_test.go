@@ -0,0 +1,182 @@
+package balancer_test
+
+import (
+	"github.com/cbergoon/glb/balancer"
+	"github.com/cbergoon/glb/registry"
+	"github.com/cbergoon/glb/registry/standardregistry"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+var targets = registry.OrderedTargets{
+	{Address: "localhost:8080"},
+	{Address: "localhost:8081"},
+	{Address: "localhost:8082"},
+}
+
+func TestRoundRobin_Select(t *testing.T) {
+	sr := &serviceregistry.StandardRegistry{}
+	for _, target := range targets {
+		sr.Add("testSvc01", "testKey01", target)
+	}
+	b := balancer.NewRoundRobin(sr)
+	for i := 0; i < 6; i++ {
+		if index := b.Select("testSvc01", "testKey01", targets, nil); index != i%3 {
+			t.Errorf("Expected index %d got %d", i%3, index)
+		}
+	}
+	weighted := registry.OrderedTargets{{Address: "a", Weight: 5}, {Address: "b"}, {Address: "c"}}
+	expected := []int{0, 0, 1, 0, 2, 0, 0}
+	for i, e := range expected {
+		if index := b.Select("testSvc01", "testKey01", weighted, nil); index != e {
+			t.Errorf("Expected index %d on selection %d got %d", e, i, index)
+		}
+	}
+}
+
+func TestRandom_Select(t *testing.T) {
+	b := &balancer.Random{}
+	weighted := registry.OrderedTargets{{Address: "a", Weight: 3}, {Address: "b"}}
+	counts := make([]int, 2)
+	for i := 0; i < 4000; i++ {
+		counts[b.Select("testSvc01", "testKey01", weighted, nil)]++
+	}
+	if counts[0] < 2700 || counts[0] > 3300 {
+		t.Error("Expected roughly 3000 selections of weighted target got ", counts)
+	}
+}
+
+func TestLeastConnections_Select(t *testing.T) {
+	conns := balancer.NewCounter()
+	b := balancer.NewLeastConnections(conns)
+	conns.Increment("testSvc01", "testKey01", "localhost:8080")
+	conns.Increment("testSvc01", "testKey01", "localhost:8081")
+	conns.Increment("testSvc01", "testKey01", "localhost:8081")
+	if index := b.Select("testSvc01", "testKey01", targets, nil); index != 2 {
+		t.Error("Expected index 2 got ", index)
+	}
+	conns.Increment("testSvc01", "testKey01", "localhost:8082")
+	conns.Increment("testSvc01", "testKey01", "localhost:8082")
+	if index := b.Select("testSvc01", "testKey01", targets, nil); index != 0 {
+		t.Error("Expected index 0 got ", index)
+	}
+	weighted := registry.OrderedTargets{{Address: "localhost:8080"}, {Address: "localhost:8081", Weight: 4}}
+	if index := b.Select("testSvc01", "testKey01", weighted, nil); index != 1 {
+		t.Error("Expected weighted index 1 got ", index)
+	}
+	if count := conns.Decrement("testSvc01", "testKey01", "localhost:8080"); count != 0 {
+		t.Error("Expected count of 0 got ", count)
+	}
+}
+
+func TestPowerOfTwoChoices_Select(t *testing.T) {
+	conns := balancer.NewCounter()
+	b := balancer.NewPowerOfTwoChoices(conns)
+	for i := 0; i < 10; i++ {
+		conns.Increment("testSvc01", "testKey01", "localhost:8080")
+	}
+	for i := 0; i < 100; i++ {
+		if index := b.Select("testSvc01", "testKey01", targets, nil); index == 0 {
+			t.Fatal("Expected most loaded target to never be chosen")
+		}
+	}
+	if index := b.Select("testSvc01", "testKey01", targets[:1], nil); index != 0 {
+		t.Error("Expected index 0 got ", index)
+	}
+}
+
+func TestSelector_Configure(t *testing.T) {
+	s := balancer.NewSelector(&serviceregistry.StandardRegistry{})
+	if _, ok := s.Balancer("testSvc01", "testKey01").(*balancer.RoundRobin); !ok {
+		t.Error("Expected round robin by default")
+	}
+	err := s.Configure(map[string]map[string]balancer.Settings{"testSvc01": {"testKey01": {Name: balancer.POWER_OF_TWO}}})
+	if err != nil {
+		t.Error("Expected nil error got ", err)
+	}
+	if _, ok := s.Balancer("testSvc01", "testKey01").(*balancer.PowerOfTwoChoices); !ok {
+		t.Error("Expected power of two choices got ", s.Balancer("testSvc01", "testKey01"))
+	}
+	err = s.Configure(map[string]map[string]balancer.Settings{"testSvc01": {"testKey01": {Name: "unknown"}}})
+	if err != balancer.ErrUnknownBalancer {
+		t.Error("Expected ErrUnknownBalancer got ", err)
+	}
+	err = s.Configure(map[string]map[string]balancer.Settings{"testSvc01": {"testKey01": {Name: balancer.CONSISTENT_HASH}}})
+	if err != balancer.ErrInvalidHashKey {
+		t.Error("Expected ErrInvalidHashKey got ", err)
+	}
+	if _, ok := s.Balancer("testSvc01", "testKey01").(*balancer.PowerOfTwoChoices); !ok {
+		t.Error("Expected configuration to be unchanged got ", s.Balancer("testSvc01", "testKey01"))
+	}
+	table, err := balancer.Compile(map[string]map[string]balancer.Settings{"testSvc01": {"testKey01": {Name: balancer.RANDOM}}})
+	if err != nil {
+		t.Fatal("Expected nil error got ", err)
+	}
+	if _, ok := s.Balancer("testSvc01", "testKey01").(*balancer.PowerOfTwoChoices); !ok {
+		t.Error("Expected compiled table not to apply until Apply got ", s.Balancer("testSvc01", "testKey01"))
+	}
+	s.Apply(table)
+	if _, ok := s.Balancer("testSvc01", "testKey01").(*balancer.Random); !ok {
+		t.Error("Expected random after Apply got ", s.Balancer("testSvc01", "testKey01"))
+	}
+}
+
+func TestLeastRequests_Select(t *testing.T) {
+	requests := balancer.NewCounter()
+	b := balancer.NewLeastRequests(requests)
+	requests.Increment("testSvc01", "testKey01", "localhost:8080")
+	requests.Increment("testSvc01", "testKey01", "localhost:8082")
+	if index := b.Select("testSvc01", "testKey01", targets, nil); index != 1 {
+		t.Error("Expected index 1 got ", index)
+	}
+	snapshot := requests.Snapshot()
+	if snapshot["testSvc01"]["testKey01"]["localhost:8080"] != 1 || len(snapshot["testSvc01"]["testKey01"]) != 2 {
+		t.Error("Expected snapshot of in-flight requests got ", snapshot)
+	}
+}
+
+func TestConsistentHash_Select(t *testing.T) {
+	s := balancer.NewSelector(&serviceregistry.StandardRegistry{})
+	err := s.Configure(map[string]map[string]balancer.Settings{
+		"testSvc01": {"testKey01": {Name: balancer.CONSISTENT_HASH, HashKey: &balancer.HashKey{Source: "header", Name: "X-User"}}},
+	})
+	if err != nil {
+		t.Fatal("Expected nil error got ", err)
+	}
+	b := s.Balancer("testSvc01", "testKey01")
+	four := append(registry.OrderedTargets{{Address: "localhost:8083"}}, targets...)
+	owners := func(ts registry.OrderedTargets) map[string]string {
+		m := make(map[string]string)
+		for i := 0; i < 10000; i++ {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("X-User", "user-"+strconv.Itoa(i))
+			m[req.Header.Get("X-User")] = ts[b.Select("testSvc01", "testKey01", ts, req)].Address
+		}
+		return m
+	}
+	before := owners(targets)
+	if again := owners(targets); !reflect.DeepEqual(before, again) {
+		t.Error("Expected the same key to select the same target")
+	}
+	after := owners(four)
+	moved := 0
+	for k := range before {
+		if before[k] != after[k] {
+			moved++
+			if after[k] != "localhost:8083" {
+				t.Fatal("Expected keys to only move to the added target got ", after[k])
+			}
+		}
+	}
+	if moved < 1500 || moved > 3500 {
+		t.Error("Expected roughly a quarter of keys to move got ", moved)
+	}
+	removed := owners(four[:3])
+	for k := range after {
+		if after[k] != "localhost:8082" && after[k] != removed[k] {
+			t.Fatal("Expected only keys of the removed target to move got ", k)
+		}
+	}
+}
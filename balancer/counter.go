@@ -0,0 +1,66 @@
+package balancer
+
+import "sync"
+
+type Counter struct {
+	lock   sync.Mutex     //Exclusive lock for counts.
+	counts map[target]int //Count by service/version/address.
+}
+
+type target struct {
+	Service string
+	Key     string
+	Address string
+}
+
+//Creates a counter of open connections or requests per target.
+func NewCounter() *Counter {
+	return &Counter{counts: make(map[target]int)}
+}
+
+//Increments the count of the target and returns the new count.
+func (c *Counter) Increment(svcValue string, keyValue string, address string) int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	t := target{svcValue, keyValue, address}
+	c.counts[t]++
+	return c.counts[t]
+}
+
+//Decrements the count of the target and returns the new count. Targets are forgotten once
+//their count reaches zero.
+func (c *Counter) Decrement(svcValue string, keyValue string, address string) int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	t := target{svcValue, keyValue, address}
+	c.counts[t]--
+	if c.counts[t] <= 0 {
+		delete(c.counts, t)
+		return 0
+	}
+	return c.counts[t]
+}
+
+//Returns the count of the target.
+func (c *Counter) Count(svcValue string, keyValue string, address string) int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.counts[target{svcValue, keyValue, address}]
+}
+
+//Returns the non-zero counts by service, version and address.
+func (c *Counter) Snapshot() map[string]map[string]map[string]int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	snapshot := make(map[string]map[string]map[string]int)
+	for t, count := range c.counts {
+		if snapshot[t.Service] == nil {
+			snapshot[t.Service] = make(map[string]map[string]int)
+		}
+		if snapshot[t.Service][t.Key] == nil {
+			snapshot[t.Service][t.Key] = make(map[string]int)
+		}
+		snapshot[t.Service][t.Key][t.Address] = count
+	}
+	return snapshot
+}
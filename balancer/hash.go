@@ -0,0 +1,174 @@
+package balancer
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/cbergoon/glb/registry"
+)
+
+const VIRTUAL_NODES = 100 //Ring points per unit of target weight.
+
+type HashKey struct {
+	Source string //Part of the request hashed; header, cookie, query, ip or path.
+	Name   string //Header, cookie or query parameter name; unused for ip and path.
+}
+
+type ConsistentHash struct {
+	lock  sync.RWMutex       //Exclusive lock for keys and rings.
+	keys  map[string]HashKey //Hash key by service/version.
+	rings map[string]*ring   //Ring by service/version; rebuilt when the targets change.
+}
+
+type ring struct {
+	signature string   //Addresses and weights the ring was built from.
+	points    []uint64 //Sorted hashes of the virtual nodes.
+	owners    []string //Address owning each point.
+}
+
+//Creates a consistent hash balancer. Requests with the same key are routed to the same
+//target while it is available.
+func NewConsistentHash() *ConsistentHash {
+	return &ConsistentHash{keys: make(map[string]HashKey), rings: make(map[string]*ring)}
+}
+
+//Replaces the hash key of each service/version.
+func (b *ConsistentHash) Configure(keys map[string]map[string]HashKey) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.keys = make(map[string]HashKey)
+	for svc := range keys {
+		for key, k := range keys[svc] {
+			b.keys[svc+"/"+key] = k
+		}
+	}
+}
+
+//Hashes the configured key of the request onto a ring of virtual nodes and selects the
+//target owning the next point. Each target has VIRTUAL_NODES points per unit of weight so
+//adding or removing a target only remaps the keys adjacent to its points. Requests without
+//a key are balanced at random.
+func (b *ConsistentHash) Select(svcValue string, keyValue string, targets registry.OrderedTargets, req *http.Request) int {
+	b.lock.RLock()
+	hk, ok := b.keys[svcValue+"/"+keyValue]
+	b.lock.RUnlock()
+	value := ""
+	if ok {
+		value = hk.value(req)
+	}
+	if value == "" {
+		return rand.Intn(len(targets))
+	}
+	r := b.ring(svcValue+"/"+keyValue, targets)
+	h := hash(value)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	for j := range targets {
+		if targets[j].Address == r.owners[i] {
+			return j
+		}
+	}
+	return 0
+}
+
+//Returns the ring of service/version building it if the targets have changed.
+func (b *ConsistentHash) ring(name string, targets registry.OrderedTargets) *ring {
+	var sig strings.Builder
+	for i := range targets {
+		sig.WriteString(targets[i].Address)
+		sig.WriteByte('=')
+		sig.WriteString(strconv.Itoa(targets[i].EffectiveWeight()))
+		sig.WriteByte(',')
+	}
+	b.lock.RLock()
+	r, ok := b.rings[name]
+	b.lock.RUnlock()
+	if ok && r.signature == sig.String() {
+		return r
+	}
+	r = &ring{signature: sig.String()}
+	type point struct {
+		hash  uint64
+		owner string
+	}
+	var points []point
+	for i := range targets {
+		for v := 0; v < VIRTUAL_NODES*targets[i].EffectiveWeight(); v++ {
+			points = append(points, point{hash(targets[i].Address + "#" + strconv.Itoa(v)), targets[i].Address})
+		}
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].hash < points[j].hash })
+	for _, p := range points {
+		r.points = append(r.points, p.hash)
+		r.owners = append(r.owners, p.owner)
+	}
+	b.lock.Lock()
+	b.rings[name] = r
+	b.lock.Unlock()
+	return r
+}
+
+//Reports whether the source is known and a name is given where the source requires one.
+func (k HashKey) valid() bool {
+	switch k.Source {
+	case "header", "cookie", "query":
+		return k.Name != ""
+	case "ip", "path":
+		return true
+	}
+	return false
+}
+
+//Extracts the key from the request. Returns an empty string if the request does not
+//carry the key.
+func (k HashKey) value(req *http.Request) string {
+	if req == nil {
+		return ""
+	}
+	switch k.Source {
+	case "header":
+		return req.Header.Get(k.Name)
+	case "cookie":
+		c, err := req.Cookie(k.Name)
+		if err != nil {
+			return ""
+		}
+		return c.Value
+	case "query":
+		return req.URL.Query().Get(k.Name)
+	case "ip":
+		host, _, err := net.SplitHostPort(req.RemoteAddr)
+		if err != nil {
+			return req.RemoteAddr
+		}
+		return host
+	case "path":
+		return req.URL.Path
+	}
+	return ""
+}
+
+func hash(value string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(value))
+	return mix(h.Sum64())
+}
+
+//Finalizes the FNV hash so keys differing only in trailing characters, such as the virtual
+//node suffix, spread across the ring.
+func mix(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
@@ -0,0 +1,37 @@
+package balancer
+
+import (
+	"sync"
+	"time"
+)
+
+//Most recent error of each target as seen by the proxy.
+type LastErrors struct {
+	lock   sync.Mutex           //Exclusive lock for errors.
+	errors map[target]LastError //Last error by service/version/address.
+}
+
+type LastError struct {
+	Error string    //Description of the error.
+	Time  time.Time //Time the error occurred.
+}
+
+//Creates an empty record of errors per target.
+func NewLastErrors() *LastErrors {
+	return &LastErrors{errors: make(map[target]LastError)}
+}
+
+//Records err as the last error of the target.
+func (l *LastErrors) Record(svcValue string, keyValue string, address string, err error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.errors[target{svcValue, keyValue, address}] = LastError{Error: err.Error(), Time: time.Now()}
+}
+
+//Returns the last error of the target and whether one has been recorded.
+func (l *LastErrors) Get(svcValue string, keyValue string, address string) (LastError, bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	e, ok := l.errors[target{svcValue, keyValue, address}]
+	return e, ok
+}
@@ -0,0 +1,54 @@
+package balancer
+
+import (
+	"math/rand"
+	"net/http"
+
+	"github.com/cbergoon/glb/registry"
+)
+
+type LeastConnections struct {
+	conns *Counter //Open connections per target.
+}
+
+//Creates a balancer that selects the target with the fewest open connections.
+func NewLeastConnections(conns *Counter) *LeastConnections {
+	return &LeastConnections{conns: conns}
+}
+
+//Selects the target with the fewest open connections relative to its weight. Ties are
+//broken at random so idle targets share load evenly.
+func (b *LeastConnections) Select(svcValue string, keyValue string, targets registry.OrderedTargets, req *http.Request) int {
+	return leastLoaded(b.conns, svcValue, keyValue, targets)
+}
+
+//Returns the index of the target with the lowest count relative to its weight. Ties are
+//broken at random.
+func leastLoaded(c *Counter, svcValue string, keyValue string, targets registry.OrderedTargets) int {
+	best, ties := 0, 0
+	for i := range targets {
+		switch compareLoad(c, svcValue, keyValue, targets[i], targets[best]) {
+		case -1:
+			best, ties = i, 1
+		case 0:
+			ties++
+			if rand.Intn(ties) == 0 {
+				best = i
+			}
+		}
+	}
+	return best
+}
+
+//Compares the load of two targets relative to their weights. Returns -1 if a is less loaded
+//than b, 1 if a is more loaded and, 0 if they are equally loaded.
+func compareLoad(c *Counter, svcValue string, keyValue string, a, b registry.Target) int {
+	la := c.Count(svcValue, keyValue, a.Address) * b.EffectiveWeight()
+	lb := c.Count(svcValue, keyValue, b.Address) * a.EffectiveWeight()
+	if la < lb {
+		return -1
+	} else if la > lb {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,23 @@
+package balancer
+
+import (
+	"net/http"
+
+	"github.com/cbergoon/glb/registry"
+)
+
+type LeastRequests struct {
+	requests *Counter //In-flight requests per target.
+}
+
+//Creates a balancer that selects the target with the fewest outstanding requests.
+func NewLeastRequests(requests *Counter) *LeastRequests {
+	return &LeastRequests{requests: requests}
+}
+
+//Selects the target with the fewest in-flight requests relative to its weight. Unlike open
+//connections the count reflects requests still awaiting a response, steering load away from
+//slow targets. Ties are broken at random.
+func (b *LeastRequests) Select(svcValue string, keyValue string, targets registry.OrderedTargets, req *http.Request) int {
+	return leastLoaded(b.requests, svcValue, keyValue, targets)
+}
@@ -0,0 +1,34 @@
+package balancer
+
+import (
+	"math/rand"
+	"net/http"
+
+	"github.com/cbergoon/glb/registry"
+)
+
+type PowerOfTwoChoices struct {
+	conns *Counter //Open connections per target.
+}
+
+//Creates a balancer that selects the less loaded of two random targets.
+func NewPowerOfTwoChoices(conns *Counter) *PowerOfTwoChoices {
+	return &PowerOfTwoChoices{conns: conns}
+}
+
+//Picks two distinct targets at random and selects the one with fewer open connections
+//relative to its weight. Avoids the herding of least connections at a fraction of the cost.
+func (b *PowerOfTwoChoices) Select(svcValue string, keyValue string, targets registry.OrderedTargets, req *http.Request) int {
+	if len(targets) == 1 {
+		return 0
+	}
+	i := rand.Intn(len(targets))
+	j := rand.Intn(len(targets) - 1)
+	if j >= i {
+		j++
+	}
+	if compareLoad(b.conns, svcValue, keyValue, targets[j], targets[i]) < 0 {
+		return j
+	}
+	return i
+}
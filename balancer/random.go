@@ -0,0 +1,26 @@
+package balancer
+
+import (
+	"math/rand"
+	"net/http"
+
+	"github.com/cbergoon/glb/registry"
+)
+
+type Random struct{}
+
+//Selects a target at random with probability proportional to its weight.
+func (b *Random) Select(svcValue string, keyValue string, targets registry.OrderedTargets, req *http.Request) int {
+	total := 0
+	for i := range targets {
+		total += targets[i].EffectiveWeight()
+	}
+	n := rand.Intn(total)
+	for i := range targets {
+		n -= targets[i].EffectiveWeight()
+		if n < 0 {
+			return i
+		}
+	}
+	return len(targets) - 1
+}
@@ -0,0 +1,31 @@
+package balancer
+
+import (
+	"net/http"
+
+	"github.com/cbergoon/glb/registry"
+)
+
+type RoundRobin struct {
+	reg     registry.Registry //Registry holding the round robbin counter of each service/version.
+	weights smoothWeighted    //Current weights of weighted service/versions.
+}
+
+//Creates a round robin balancer that keeps its position in the registry round robbin counter.
+func NewRoundRobin(reg registry.Registry) *RoundRobin {
+	return &RoundRobin{reg: reg}
+}
+
+//Selects targets in turn using the registry round robbin counter. If any target carries a
+//weight the targets are selected by smooth weighted round robin instead.
+func (b *RoundRobin) Select(svcValue string, keyValue string, targets registry.OrderedTargets, req *http.Request) int {
+	if targets.Weighted() {
+		return b.weights.next(svcValue, keyValue, targets)
+	}
+	counter, err := b.reg.GetRoundRobbinCounter(svcValue, keyValue)
+	if err != nil || counter < 0 || counter >= len(targets) {
+		counter = 0
+	}
+	b.reg.SetRoundRobbinCounter(svcValue, keyValue, counter+1)
+	return counter
+}
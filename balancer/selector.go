@@ -0,0 +1,125 @@
+package balancer
+
+import (
+	"sync"
+
+	"github.com/cbergoon/glb/registry"
+)
+
+type Selector struct {
+	lock        sync.RWMutex                    //Exclusive lock for names and affinities.
+	names       map[string]map[string]string    //Balancer name by service/version.
+	balancers   map[string]Balancer             //Balancer by name.
+	affinities  map[string]map[string]*Affinity //Session affinity by service/version.
+	hash        *ConsistentHash                 //Consistent hash balancer; holds the hash key of each service/version.
+	Connections *Counter                        //Open connections per target; maintained by the proxy.
+	Requests    *Counter                        //In-flight requests per target; maintained by the proxy.
+	Errors      *LastErrors                     //Last error of each target; maintained by the proxy.
+}
+
+//Creates a selector providing the balancer of each service/version. Service/versions are
+//balanced by round robin until configured otherwise.
+func NewSelector(reg registry.Registry) *Selector {
+	conns := NewCounter()
+	requests := NewCounter()
+	hash := NewConsistentHash()
+	return &Selector{
+		names:      make(map[string]map[string]string),
+		affinities: make(map[string]map[string]*Affinity),
+		balancers: map[string]Balancer{
+			ROUND_ROBIN:       NewRoundRobin(reg),
+			RANDOM:            &Random{},
+			LEAST_CONNECTIONS: NewLeastConnections(conns),
+			LEAST_REQUESTS:    NewLeastRequests(requests),
+			POWER_OF_TWO:      NewPowerOfTwoChoices(conns),
+			CONSISTENT_HASH:   hash,
+		},
+		hash:        hash,
+		Connections: conns,
+		Requests:    requests,
+		Errors:      NewLastErrors(),
+	}
+}
+
+//Balancer names, hash keys and affinities by service/version prepared by Compile and applied
+//at once by Apply.
+type Table struct {
+	names      map[string]map[string]string
+	keys       map[string]map[string]HashKey
+	affinities map[string]map[string]*Affinity
+}
+
+//Replaces the balancer settings by service/version. An empty name selects round robin.
+//Returns ErrUnknownBalancer or ErrInvalidHashKey and leaves the current settings in place if
+//any name is not known or a consistent hash balancer lacks a valid hash key.
+func (s *Selector) Configure(settings map[string]map[string]Settings) error {
+	t, err := Compile(settings)
+	if err != nil {
+		return err
+	}
+	s.Apply(t)
+	return nil
+}
+
+//Validates the balancer settings by service/version as Configure does, without applying them.
+func Compile(settings map[string]map[string]Settings) (*Table, error) {
+	t := &Table{
+		names:      make(map[string]map[string]string),
+		keys:       make(map[string]map[string]HashKey),
+		affinities: make(map[string]map[string]*Affinity),
+	}
+	for svc := range settings {
+		t.names[svc] = make(map[string]string)
+		t.keys[svc] = make(map[string]HashKey)
+		t.affinities[svc] = make(map[string]*Affinity)
+		for key, set := range settings[svc] {
+			if err := set.Validate(); err != nil {
+				return nil, err
+			}
+			if set.Name == CONSISTENT_HASH {
+				t.keys[svc][key] = *set.HashKey
+			}
+			t.names[svc][key] = set.Name
+			if set.Affinity != nil {
+				t.affinities[svc][key] = set.Affinity
+			}
+		}
+	}
+	return t, nil
+}
+
+//Replaces the balancer settings with the table.
+func (s *Selector) Apply(t *Table) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.names = t.names
+	s.affinities = t.affinities
+	s.hash.Configure(t.keys)
+}
+
+//Returns the name of the balancer configured for service/version.
+func (s *Selector) Name(svcValue string, keyValue string) string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if name := s.names[svcValue][keyValue]; name != "" {
+		return name
+	}
+	return ROUND_ROBIN
+}
+
+//Returns the balancer configured for service/version.
+func (s *Selector) Balancer(svcValue string, keyValue string) Balancer {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if b, ok := s.balancers[s.names[svcValue][keyValue]]; ok {
+		return b
+	}
+	return s.balancers[ROUND_ROBIN]
+}
+
+//Returns the session affinity configured for service/version or nil if affinity is disabled.
+func (s *Selector) Affinity(svcValue string, keyValue string) *Affinity {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.affinities[svcValue][keyValue]
+}
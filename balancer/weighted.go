@@ -0,0 +1,40 @@
+package balancer
+
+import (
+	"sync"
+
+	"github.com/cbergoon/glb/registry"
+)
+
+//Smooth weighted round robin state as used by nginx. Each selection adds every target's
+//weight to its current weight, picks the target with the highest current weight and,
+//subtracts the total weight from the chosen target. Targets are interleaved in proportion
+//to their weights; weights of 5, 1 and 1 yield a, a, b, a, c, a, a.
+type smoothWeighted struct {
+	lock    sync.Mutex                //Exclusive lock for current weights.
+	current map[string]map[string]int //Current weight by service/version and address.
+}
+
+//Selects the next target of service/version and returns its index within targets. The
+//current weights of targets no longer present in targets are discarded.
+func (s *smoothWeighted) next(svcValue string, keyValue string, targets registry.OrderedTargets) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.current == nil {
+		s.current = make(map[string]map[string]int)
+	}
+	prev := s.current[svcValue+"/"+keyValue]
+	current := make(map[string]int, len(targets))
+	total, best := 0, 0
+	for i := range targets {
+		weight := targets[i].EffectiveWeight()
+		current[targets[i].Address] = prev[targets[i].Address] + weight
+		total += weight
+		if current[targets[i].Address] > current[targets[best].Address] {
+			best = i
+		}
+	}
+	current[targets[best].Address] -= total
+	s.current[svcValue+"/"+keyValue] = current
+	return best
+}
@@ -0,0 +1,235 @@
+package breaker
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/cbergoon/glb/registry"
+)
+
+const (
+	CLOSED    = "closed"    //Target receives traffic.
+	OPEN      = "open"      //Target is ejected until the cooldown elapses.
+	HALF_OPEN = "half-open" //A single probe request decides whether the target is restored.
+)
+
+var ErrInvalidSettings = errors.New("breaker: threshold, cooldown and timeout must not be negative")
+
+type Settings struct {
+	Threshold       int //Consecutive failures that open the breaker; defaults to 5.
+	CooldownSeconds int //Seconds the target is ejected before a probe is allowed; defaults to 30.
+	TimeoutSeconds  int //Seconds to wait for response headers before the attempt counts as a failure; zero means no limit.
+}
+
+type Breakers struct {
+	lock     sync.Mutex                     //Exclusive lock for settings and states.
+	reg      registry.Registry              //Registry holding the failure count of each target.
+	settings map[string]map[string]Settings //Breaker settings by service/version.
+	states   map[target]*state              //Breaker state by service/version/address.
+}
+
+type target struct {
+	Service string
+	Key     string
+	Address string
+}
+
+type state struct {
+	State    string    //CLOSED, OPEN or HALF_OPEN.
+	Failures bool      //Whether the registry failure count of the target is non-zero.
+	OpenedAt time.Time //Time the breaker last opened.
+	Probing  bool      //Whether the half-open probe request is in flight.
+}
+
+//Creates circuit breakers for targets in the registry. Failures are counted on the registry
+//targets with IncrementFailures. No breaker opens until settings are provided with Configure.
+func NewBreakers(reg registry.Registry) *Breakers {
+	return &Breakers{
+		reg:      reg,
+		settings: make(map[string]map[string]Settings),
+		states:   make(map[target]*state),
+	}
+}
+
+//Replaces the breaker settings. Service/versions without settings never eject targets; their
+//failure counts are still kept.
+func (b *Breakers) Configure(settings map[string]map[string]Settings) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.settings = make(map[string]map[string]Settings)
+	for svc := range settings {
+		b.settings[svc] = make(map[string]Settings)
+		for key, s := range settings[svc] {
+			b.settings[svc][key] = withDefaults(s)
+		}
+	}
+	for t, st := range b.states {
+		if _, ok := b.settings[t.Service][t.Key]; !ok {
+			st.State, st.Probing = CLOSED, false
+		}
+	}
+}
+
+//Returns the settings of service/version and whether breaking is enabled for it.
+func (b *Breakers) Settings(svcValue string, keyValue string) (Settings, bool) {
+	if b == nil {
+		return Settings{}, false
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	s, ok := b.settings[svcValue][keyValue]
+	return s, ok
+}
+
+//Reports whether the target may be considered for a request. Targets with an open breaker
+//are unavailable until the cooldown elapses; a half-open target is unavailable while its
+//probe is in flight.
+func (b *Breakers) Available(svcValue string, keyValue string, address string) bool {
+	if b == nil {
+		return true
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	st, ok := b.states[target{svcValue, keyValue, address}]
+	if !ok {
+		return true
+	}
+	switch st.State {
+	case OPEN:
+		s := b.settings[svcValue][keyValue]
+		return time.Now().Sub(st.OpenedAt) >= time.Duration(s.CooldownSeconds)*time.Second
+	case HALF_OPEN:
+		return !st.Probing
+	}
+	return true
+}
+
+//Claims the target for a request. An open breaker whose cooldown has elapsed moves to
+//half-open and the request becomes its probe. Returns false if the target is not available.
+func (b *Breakers) Allow(svcValue string, keyValue string, address string) bool {
+	if b == nil {
+		return true
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	st, ok := b.states[target{svcValue, keyValue, address}]
+	if !ok {
+		return true
+	}
+	switch st.State {
+	case OPEN:
+		s := b.settings[svcValue][keyValue]
+		if time.Now().Sub(st.OpenedAt) < time.Duration(s.CooldownSeconds)*time.Second {
+			return false
+		}
+		st.State = HALF_OPEN
+		st.Probing = true
+		return true
+	case HALF_OPEN:
+		if st.Probing {
+			return false
+		}
+		st.Probing = true
+		return true
+	}
+	return true
+}
+
+//Records a successful request. Resets the failure count of the target and closes a
+//half-open breaker.
+func (b *Breakers) Success(svcValue string, keyValue string, address string) {
+	if b == nil {
+		return
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	st, ok := b.states[target{svcValue, keyValue, address}]
+	if !ok {
+		return
+	}
+	if st.Failures {
+		failures, err := b.reg.IncrementFailures(svcValue, keyValue, registry.Target{Address: address}, 0)
+		if err == nil {
+			b.reg.IncrementFailures(svcValue, keyValue, registry.Target{Address: address}, -failures)
+		}
+	}
+	delete(b.states, target{svcValue, keyValue, address})
+}
+
+//Records a failed request; a dial error, 5xx response or timeout. Increments the failure
+//count of the target, which outlier detection also reads, whether or not breaking is enabled
+//for service/version. Opens the breaker once the count reaches the threshold. A failed probe
+//reopens a half-open breaker.
+func (b *Breakers) Failure(svcValue string, keyValue string, address string) {
+	if b == nil {
+		return
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	t := target{svcValue, keyValue, address}
+	st, ok := b.states[t]
+	if !ok {
+		st = &state{State: CLOSED}
+		b.states[t] = st
+	}
+	failures, err := b.reg.IncrementFailures(svcValue, keyValue, registry.Target{Address: address}, 1)
+	if err != nil {
+		return
+	}
+	st.Failures = true
+	s, ok := b.settings[svcValue][keyValue]
+	if !ok {
+		return
+	}
+	if st.State == HALF_OPEN || (st.State == CLOSED && failures >= s.Threshold) {
+		st.State = OPEN
+		st.OpenedAt = time.Now()
+	}
+	st.Probing = false
+}
+
+//Gives up the claim Allow made on the target without recording a result, as when the client
+//cancels the request. A half-open target becomes available for another probe.
+func (b *Breakers) Release(svcValue string, keyValue string, address string) {
+	if b == nil {
+		return
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if st, ok := b.states[target{svcValue, keyValue, address}]; ok {
+		st.Probing = false
+	}
+}
+
+//Returns the breaker state of the target.
+func (b *Breakers) State(svcValue string, keyValue string, address string) string {
+	if b == nil {
+		return CLOSED
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	st, ok := b.states[target{svcValue, keyValue, address}]
+	if !ok {
+		return CLOSED
+	}
+	return st.State
+}
+
+//Reports whether the settings can be applied. Returns ErrInvalidSettings if a value is negative.
+func (s Settings) Validate() error {
+	if s.Threshold < 0 || s.CooldownSeconds < 0 || s.TimeoutSeconds < 0 {
+		return ErrInvalidSettings
+	}
+	return nil
+}
+
+func withDefaults(s Settings) Settings {
+	if s.Threshold <= 0 {
+		s.Threshold = 5
+	}
+	if s.CooldownSeconds <= 0 {
+		s.CooldownSeconds = 30
+	}
+	return s
+}
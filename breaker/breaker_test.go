@@ -0,0 +1,77 @@
+package breaker_test
+
+import (
+	"github.com/cbergoon/glb/breaker"
+	"github.com/cbergoon/glb/registry"
+	"github.com/cbergoon/glb/registry/standardregistry"
+	"testing"
+	"time"
+)
+
+func TestBreakers(t *testing.T) {
+	sr := &serviceregistry.StandardRegistry{}
+	sr.Add("testSvc01", "testKey01", registry.Target{Address: "localhost:8080"})
+	b := breaker.NewBreakers(sr)
+	b.Failure("testSvc01", "testKey01", "localhost:8080")
+	if !b.Available("testSvc01", "testKey01", "localhost:8080") {
+		t.Error("Expected target without settings to be available")
+	}
+	counted, _ := sr.Lookup("testSvc01", "testKey01")
+	if counted[0].Failures != 1 {
+		t.Error("Expected failures to be counted without settings got ", counted[0].Failures)
+	}
+	b.Success("testSvc01", "testKey01", "localhost:8080")
+	b.Configure(map[string]map[string]breaker.Settings{"testSvc01": {"testKey01": {Threshold: 3, CooldownSeconds: 1}}})
+
+	b.Failure("testSvc01", "testKey01", "localhost:8080")
+	b.Failure("testSvc01", "testKey01", "localhost:8080")
+	b.Success("testSvc01", "testKey01", "localhost:8080")
+	targets, _ := sr.Lookup("testSvc01", "testKey01")
+	if targets[0].Failures != 0 {
+		t.Error("Expected success to reset failures got ", targets[0].Failures)
+	}
+	for i := 0; i < 3; i++ {
+		if state := b.State("testSvc01", "testKey01", "localhost:8080"); state != breaker.CLOSED {
+			t.Error("Expected closed breaker got ", state)
+		}
+		b.Failure("testSvc01", "testKey01", "localhost:8080")
+	}
+	if state := b.State("testSvc01", "testKey01", "localhost:8080"); state != breaker.OPEN {
+		t.Error("Expected open breaker got ", state)
+	}
+	if b.Available("testSvc01", "testKey01", "localhost:8080") || b.Allow("testSvc01", "testKey01", "localhost:8080") {
+		t.Error("Expected ejected target during cooldown")
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+	if !b.Allow("testSvc01", "testKey01", "localhost:8080") {
+		t.Error("Expected probe to be allowed after cooldown")
+	}
+	if b.Available("testSvc01", "testKey01", "localhost:8080") || b.Allow("testSvc01", "testKey01", "localhost:8080") {
+		t.Error("Expected single probe while half-open")
+	}
+	b.Release("testSvc01", "testKey01", "localhost:8080")
+	if state := b.State("testSvc01", "testKey01", "localhost:8080"); state != breaker.HALF_OPEN || !b.Available("testSvc01", "testKey01", "localhost:8080") {
+		t.Error("Expected released probe to leave half-open target available got ", state)
+	}
+	if !b.Allow("testSvc01", "testKey01", "localhost:8080") {
+		t.Error("Expected another probe after release")
+	}
+	b.Failure("testSvc01", "testKey01", "localhost:8080")
+	if state := b.State("testSvc01", "testKey01", "localhost:8080"); state != breaker.OPEN {
+		t.Error("Expected failed probe to reopen breaker got ", state)
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+	if !b.Allow("testSvc01", "testKey01", "localhost:8080") {
+		t.Error("Expected probe to be allowed after cooldown")
+	}
+	b.Success("testSvc01", "testKey01", "localhost:8080")
+	if state := b.State("testSvc01", "testKey01", "localhost:8080"); state != breaker.CLOSED {
+		t.Error("Expected successful probe to close breaker got ", state)
+	}
+	targets, _ = sr.Lookup("testSvc01", "testKey01")
+	if targets[0].Failures != 0 {
+		t.Error("Expected failures value of 0 got ", targets[0].Failures)
+	}
+}
@@ -4,45 +4,199 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"github.com/cbergoon/glb/accesslog"
+	"github.com/cbergoon/glb/admin"
+	"github.com/cbergoon/glb/balancer"
+	"github.com/cbergoon/glb/breaker"
+	"github.com/cbergoon/glb/health"
+	"github.com/cbergoon/glb/outlier"
+	"github.com/cbergoon/glb/proxy"
 	"github.com/cbergoon/glb/registry"
+	"github.com/cbergoon/glb/tracing"
 	"io"
 	"io/ioutil"
-	"log"
+	"strings"
 )
 
 var (
 	ErrFailedToParse    = errors.New("proxy-config: failed to parse configuration")
 	ErrFailedToReadFile = errors.New("proxy-config: failed to read configuration file")
+	ErrInvalidConfig    = errors.New("proxy-config: invalid configuration")
 )
 
 type ProxyConfig struct {
 	Host                   Provider                                //GLB host descriptor.
+	Storage                Storage                                 //Registry implementation backing the proxy.
 	Basic                  bool                                    //Basic mode for "default" service/version.
 	DisableKeepAlives      bool                                    //Disable keepalives causing a redial on each request.
 	IdleConnTimeoutSeconds int                                     //Timeout idle connections after in seconds; zero means no limit.
 	Registry               map[string]map[string][]registry.Target //Registry represented by the configuration.
+	Policies               map[string]map[string]Policy            //Per service/version behaviour; optional.
+	Admin                  admin.Settings                          //Authentication and TLS of the management endpoints.
+	AccessLog              *accesslog.Settings                     //Format and output of the access log; disabled if nil.
+	Tracing                *tracing.Settings                       //Request ID and W3C trace context propagation; disabled if nil.
+	Routing                *proxy.RoutingSettings                  //Sources of the service/version of a request; path only if nil.
+	VirtualHosts           map[string]proxy.Destination            //Service/version of each hostname or *.domain wildcard.
+	Routes                 []proxy.Route                           //Ordered route table tried before any other routing.
+	Splits                 map[string]map[string]proxy.Split       //Weighted versions behind each service/version.
+	Mirrors                map[string]map[string]proxy.Mirror      //Service/version a share of each service/version's requests is copied to.
 }
 
 type Provider struct {
-	Addr    string //Address requests should bind to.
-	Port    string //HTTP port; used for redirect and proxy if SslPort is not specified.
-	SslPort string //HTTPS port; used for reverse proxy endpoint when specified.
+	Addr      string //Address requests should bind to.
+	Port      string //HTTP port; used for redirect and proxy if SslPort is not specified.
+	SslPort   string //HTTPS port; used for reverse proxy endpoint when specified.
+	AdminAddr string //Host:port the management endpoints listen on; disabled if blank.
+}
+
+type Policy struct {
+	HealthCheck      *health.Settings   //Active health check; targets are not checked if nil.
+	Balancer         string             //Load balancing strategy; roundrobin (default), random, leastconn, leastrequests, p2c or hash.
+	HashKey          *balancer.HashKey  //Request key hashed by the hash balancer.
+	Affinity         *balancer.Affinity //Cookie based session affinity; disabled if nil.
+	CircuitBreaker   *breaker.Settings  //Passive circuit breaking; targets are never ejected if nil.
+	OutlierDetection *outlier.Settings  //Success rate and latency outlier ejection; disabled if nil.
+}
+
+type Storage struct {
+	Type string //Registry implementation; "standard" (default, in memory) or "bunt" (persistent).
+	Path string //Database file used by the "bunt" registry.
 }
 
 //Reads the json configuration file, parses the contents into the configuration
 //object "ProxyConfig" and, returns the resulting configuration structure. Populates
-//registry argument with configuration specification. Returns ErrFailedToParse if
-//the JSON could not be marshaled, exits if the file does not exist.
+//registry argument with configuration specification. Returns the errors of ReadConfig
+//without modifying the registry if the configuration cannot be read or is invalid.
 func ReadParseConfig(configFile string, r registry.Registry) (ProxyConfig, error) {
+	config, err := ReadConfig(configFile)
+	if err != nil {
+		return config, err
+	}
+	PopulateRegistry(config, r)
+	return config, nil
+}
+
+//Reads the json configuration file, parses the contents into the configuration object
+//"ProxyConfig" and validates it without populating a registry. Returns errors wrapping
+//ErrFailedToReadFile if the file could not be read, ErrFailedToParse if the JSON could not
+//be marshaled and, ErrInvalidConfig if the configuration is not valid.
+func ReadConfig(configFile string) (ProxyConfig, error) {
 	data, err := readConfig(configFile)
 	if err != nil {
-		log.Print(err)
-		//os.Exit(-1)
+		return ProxyConfig{}, err
 	}
 	config, err := parseConfig(bytes.NewReader(data))
 	if err != nil {
-		return config, ErrFailedToParse
+		return config, err
+	}
+	return config, config.Validate()
+}
+
+//Reports whether the configuration can be applied. Returns an error wrapping ErrInvalidConfig
+//that names the offending service/version if the storage is unknown, a service/version or
+//target is malformed, basic mode lacks the "default" service or, a policy or the admin
+//credentials are invalid.
+func (p ProxyConfig) Validate() error {
+	switch p.Storage.Type {
+	case "", "standard":
+	case "bunt":
+		if p.Storage.Path == "" {
+			return fmt.Errorf("%w: bunt storage requires a path", ErrInvalidConfig)
+		}
+	default:
+		return fmt.Errorf("%w: unknown storage type %q", ErrInvalidConfig, p.Storage.Type)
+	}
+	for svc := range p.Registry {
+		for key, targets := range p.Registry[svc] {
+			if svc == "" || key == "" || strings.Contains(svc, "/") || strings.Contains(key, "/") {
+				return fmt.Errorf("%w: service/version %q/%q must be non-empty and not contain \"/\"", ErrInvalidConfig, svc, key)
+			}
+			for _, t := range targets {
+				if err := t.Validate(); err != nil {
+					return fmt.Errorf("%w: %s/%s: target %q: %v", ErrInvalidConfig, svc, key, t.Address, err)
+				}
+			}
+		}
 	}
+	if _, ok := p.Registry["default"]["default"]; p.Basic && !ok {
+		return fmt.Errorf("%w: basic mode requires the service/version default/default", ErrInvalidConfig)
+	}
+	balancers := p.Balancers()
+	for svc := range balancers {
+		for key, s := range balancers[svc] {
+			if err := s.Validate(); err != nil {
+				return fmt.Errorf("%w: %s/%s: %v", ErrInvalidConfig, svc, key, err)
+			}
+		}
+	}
+	for svc := range p.Policies {
+		for key, policy := range p.Policies[svc] {
+			if policy.HealthCheck != nil {
+				if err := policy.HealthCheck.Validate(); err != nil {
+					return fmt.Errorf("%w: %s/%s: %v", ErrInvalidConfig, svc, key, err)
+				}
+			}
+			if policy.CircuitBreaker != nil {
+				if err := policy.CircuitBreaker.Validate(); err != nil {
+					return fmt.Errorf("%w: %s/%s: %v", ErrInvalidConfig, svc, key, err)
+				}
+			}
+			if policy.OutlierDetection != nil {
+				if err := policy.OutlierDetection.Validate(); err != nil {
+					return fmt.Errorf("%w: %s/%s: %v", ErrInvalidConfig, svc, key, err)
+				}
+			}
+		}
+	}
+	if err := p.Admin.Validate(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+	}
+	if p.AccessLog != nil {
+		if err := p.AccessLog.Validate(); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+		}
+	}
+	if err := proxy.ValidateRoutes(p.Routes); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+	}
+	if err := proxy.ValidateHosts(p.VirtualHosts); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+	}
+	if err := proxy.ValidateSplits(p.Splits); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+	}
+	//The persisted registry of bunt storage is only known at runtime; the router logs splits
+	//naming versions outside it instead.
+	if p.Storage.Type == "" || p.Storage.Type == "standard" {
+		for svc := range p.Splits {
+			for key, split := range p.Splits[svc] {
+				for version := range split.Weights {
+					if _, ok := p.Registry[svc][version]; !ok {
+						return fmt.Errorf("%w: split %s/%s: version %s is not in the registry", ErrInvalidConfig, svc, key, version)
+					}
+				}
+			}
+		}
+	}
+	if err := proxy.ValidateMirrors(p.Mirrors); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+	}
+	if p.Routing != nil {
+		if err := p.Routing.Validate(); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+		}
+	}
+	if p.Tracing != nil {
+		if err := p.Tracing.Validate(); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+		}
+	}
+	return nil
+}
+
+//Adds every target in the configuration to the registry argument.
+func PopulateRegistry(config ProxyConfig, r registry.Registry) {
 	for svc := range config.Registry {
 		for key := range config.Registry[svc] {
 			for _, target := range config.Registry[svc][key] {
@@ -50,7 +204,74 @@ func ReadParseConfig(configFile string, r registry.Registry) (ProxyConfig, error
 			}
 		}
 	}
-	return config, nil
+}
+
+//Returns the health check settings of every service/version that specifies one.
+func (p ProxyConfig) HealthChecks() map[string]map[string]health.Settings {
+	settings := make(map[string]map[string]health.Settings)
+	for svc := range p.Policies {
+		for key, policy := range p.Policies[svc] {
+			if policy.HealthCheck == nil {
+				continue
+			}
+			if settings[svc] == nil {
+				settings[svc] = make(map[string]health.Settings)
+			}
+			settings[svc][key] = *policy.HealthCheck
+		}
+	}
+	return settings
+}
+
+//Returns the balancer settings of every service/version that specifies a balancer or affinity.
+func (p ProxyConfig) Balancers() map[string]map[string]balancer.Settings {
+	settings := make(map[string]map[string]balancer.Settings)
+	for svc := range p.Policies {
+		for key, policy := range p.Policies[svc] {
+			if policy.Balancer == "" && policy.Affinity == nil {
+				continue
+			}
+			if settings[svc] == nil {
+				settings[svc] = make(map[string]balancer.Settings)
+			}
+			settings[svc][key] = balancer.Settings{Name: policy.Balancer, HashKey: policy.HashKey, Affinity: policy.Affinity}
+		}
+	}
+	return settings
+}
+
+//Returns the circuit breaker settings of every service/version that specifies one.
+func (p ProxyConfig) CircuitBreakers() map[string]map[string]breaker.Settings {
+	settings := make(map[string]map[string]breaker.Settings)
+	for svc := range p.Policies {
+		for key, policy := range p.Policies[svc] {
+			if policy.CircuitBreaker == nil {
+				continue
+			}
+			if settings[svc] == nil {
+				settings[svc] = make(map[string]breaker.Settings)
+			}
+			settings[svc][key] = *policy.CircuitBreaker
+		}
+	}
+	return settings
+}
+
+//Returns the outlier detection settings of every service/version that specifies one.
+func (p ProxyConfig) OutlierDetectors() map[string]map[string]outlier.Settings {
+	settings := make(map[string]map[string]outlier.Settings)
+	for svc := range p.Policies {
+		for key, policy := range p.Policies[svc] {
+			if policy.OutlierDetection == nil {
+				continue
+			}
+			if settings[svc] == nil {
+				settings[svc] = make(map[string]outlier.Settings)
+			}
+			settings[svc][key] = *policy.OutlierDetection
+		}
+	}
+	return settings
 }
 
 //Reads the file specified by the configFile argument and returns the contents as a byte
@@ -58,13 +279,13 @@ func ReadParseConfig(configFile string, r registry.Registry) (ProxyConfig, error
 func readConfig(configFile string) ([]byte, error) {
 	data, err := ioutil.ReadFile(configFile)
 	if err != nil {
-		return nil, ErrFailedToReadFile
+		return nil, fmt.Errorf("%w: %v", ErrFailedToReadFile, err)
 	}
 	return data, nil
 }
 
-//Parses the configuration using a json decoder returns the resulting ProxyConfig. Returns
-//ErrFailedToParse if an error occurs.
+//Parses the configuration using a json decoder returns the resulting ProxyConfig. Returns an
+//error wrapping ErrFailedToParse and describing the decode error if an error occurs.
 func parseConfig(jsonStream io.Reader) (ProxyConfig, error) {
 	dec := json.NewDecoder(jsonStream)
 	var p ProxyConfig
@@ -72,7 +293,7 @@ func parseConfig(jsonStream io.Reader) (ProxyConfig, error) {
 		if err := dec.Decode(&p); err == io.EOF {
 			break
 		} else if err != nil {
-			return p, ErrFailedToParse
+			return p, fmt.Errorf("%w: %v", ErrFailedToParse, err)
 		}
 
 	}
@@ -2,8 +2,11 @@ package config_test
 
 import (
 	"bufio"
+	"errors"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 	"github.com/cbergoon/glb/registry/standardregistry"
 	"github.com/cbergoon/glb/config"
 )
@@ -99,3 +102,112 @@ func TestReadParseConfig(t *testing.T) {
 		t.Error("Could not remove files got ", err)
 	}
 }
+
+func TestProxyConfig_HealthChecks(t *testing.T) {
+	const file = "file-policies.json"
+	const data = `{"Registry": {"s1": {"v1": [{"Address": "localhost:8080"}]}},
+		"Policies": {"s1": {"v1": {"HealthCheck": {"Path": "/health", "IntervalSeconds": 5}}, "v2": {}}}}`
+	if err := os.WriteFile(file, []byte(data), 0644); err != nil {
+		t.Fatal("Could not build file got ", err)
+	}
+	defer os.Remove(file)
+	proxyConfig, err := config.ReadConfig(file)
+	if err != nil {
+		t.Fatal("Expected nil error got ", err)
+	}
+	checks := proxyConfig.HealthChecks()
+	if len(checks) != 1 || len(checks["s1"]) != 1 {
+		t.Fatal("Expected one health check got ", checks)
+	}
+	if checks["s1"]["v1"].Path != "/health" || checks["s1"]["v1"].IntervalSeconds != 5 {
+		t.Error("Health check not built properly got ", checks["s1"]["v1"])
+	}
+}
+
+func TestProxyConfig_Validate(t *testing.T) {
+	valid := `{"Registry": {"s1": {"v1": [{"Address": "localhost:8080"}]}}, "Policies": {"s1": {"v1": {"Balancer": "leastconn"}}}}`
+	invalid := []string{
+		`{"Registry": {"s1": {"v1": [{"Address": "localhost:8080"}]}}, "Policies": {"s1": {"v1": {"Balancer": "fastest"}}}}`,
+		`{"Registry": {"s1": {"v1": [{"Address": "localhost:8080"}]}}, "Policies": {"s1": {"v1": {"Balancer": "hash"}}}}`,
+		`{"Registry": {"s1": {"v1": [{"Address": ""}]}}}`,
+		`{"Registry": {"s1": {"v1": [{"Address": "localhost:8080", "Weight": -1}]}}}`,
+		`{"Basic": true, "Registry": {"s1": {"v1": [{"Address": "localhost:8080"}]}}}`,
+		`{"Storage": {"Type": "bunt"}}`,
+		`{"Storage": {"Type": "etcd"}}`,
+		`{"Admin": {"Credentials": [{"Role": "owner", "Token": "secret"}]}}`,
+		`{"AccessLog": {"Format": "xml"}}`,
+		`{"AccessLog": {"Format": "template"}}`,
+		`{"Tracing": {"Endpoint": "localhost:4318"}}`,
+		`{"Routing": {"Order": ["header", "cookie"]}}`,
+		`{"VirtualHosts": {"orders.*": {"Service": "orders", "Version": "v1"}}}`,
+		`{"VirtualHosts": {"orders.internal": {"Service": "orders"}}}`,
+		`{"Routes": [{"PathRegex": "(", "Service": "s1", "Version": "v1"}]}`,
+		`{"Routes": [{"ClientCIDRs": ["10.0.0.0"], "Service": "s1", "Version": "v1"}]}`,
+		`{"Policies": {"s1": {"v1": {"HealthCheck": {"Path": "health"}}}}}`,
+		`{"Policies": {"s1": {"v1": {"HealthCheck": {"ExpectedStatus": 2000}}}}}`,
+		`{"Policies": {"s1": {"v1": {"CircuitBreaker": {"Threshold": -1}}}}}`,
+		`{"Policies": {"s1": {"v1": {"OutlierDetection": {"MaxEjectionPercent": 150}}}}}`,
+		`{"Splits": {"s1": {"v1": {"Weights": {"v1": 0, "v2": 0}}}}}`,
+		`{"Splits": {"s1": {"v1": {"Weights": {"v1": 95, "v2": -5}}}}}`,
+		`{"Registry": {"s1": {"v1": [{"Address": "localhost:8080"}]}}, "Splits": {"s1": {"v1": {"Weights": {"v1": 95, "v2": 5}}}}}`,
+		`{"Mirrors": {"s1": {"v1": {"Service": "s1", "Version": "v2", "Percent": 150}}}}`,
+		`{"Mirrors": {"s1": {"v1": {"Service": "s1", "Percent": 10}}}}`,
+	}
+	const file = "file-validate.json"
+	defer os.Remove(file)
+	if err := os.WriteFile(file, []byte(valid), 0644); err != nil {
+		t.Fatal("Could not build file got ", err)
+	}
+	if _, err := config.ReadConfig(file); err != nil {
+		t.Error("Expected nil error got ", err)
+	}
+	for _, data := range invalid {
+		if err := os.WriteFile(file, []byte(data), 0644); err != nil {
+			t.Fatal("Could not build file got ", err)
+		}
+		if _, err := config.ReadConfig(file); !errors.Is(err, config.ErrInvalidConfig) {
+			t.Error("Expected ErrInvalidConfig for ", data, " got ", err)
+		}
+	}
+	if err := os.WriteFile(file, []byte(`{"Basic": }`), 0644); err != nil {
+		t.Fatal("Could not build file got ", err)
+	}
+	if _, err := config.ReadConfig(file); !errors.Is(err, config.ErrFailedToParse) {
+		t.Error("Expected ErrFailedToParse got ", err)
+	}
+
+	r := &serviceregistry.StandardRegistry{}
+	if _, err := config.ReadParseConfig(file, r); err == nil || len(r.ServiceNames()) != 0 {
+		t.Error("Expected invalid configuration to leave registry empty got ", err, r.ServiceNames())
+	}
+}
+
+func TestWatch(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "glb.json")
+	if err := os.WriteFile(file, []byte(`{}`), 0644); err != nil {
+		t.Fatal("Could not build file got ", err)
+	}
+	reloads := make(chan struct{}, 10)
+	w, err := config.Watch(file, 100*time.Millisecond, func() { reloads <- struct{}{} })
+	if err != nil {
+		t.Fatal("Expected nil error got ", err)
+	}
+	defer w.Close()
+	os.WriteFile(filepath.Join(filepath.Dir(file), "other.json"), []byte(`{}`), 0644)
+	for i := 0; i < 3; i++ {
+		if err := os.WriteFile(file, []byte(`{"Basic": false}`), 0644); err != nil {
+			t.Fatal("Could not write file got ", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	select {
+	case <-reloads:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected reload after writes")
+	}
+	select {
+	case <-reloads:
+		t.Error("Expected rapid writes to be debounced into a single reload")
+	case <-time.After(300 * time.Millisecond):
+	}
+}
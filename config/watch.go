@@ -0,0 +1,90 @@
+package config
+
+import (
+	"log"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const DEBOUNCE_DELAY = 500 * time.Millisecond //Quiet period after a change before reloading.
+
+//Watches a configuration file and calls a reload function once the file has stopped changing.
+type Watcher struct {
+	watcher *fsnotify.Watcher //Watcher of the directory holding the file.
+	file    string            //Cleaned path of the watched file.
+	delay   time.Duration     //Quiet period after the last change before reload is called.
+	reload  func()            //Called once per burst of changes.
+	lock    sync.Mutex        //Exclusive lock for timer.
+	timer   *time.Timer       //Pending reload; nil if none.
+	done    chan struct{}     //Closed when the event loop exits.
+}
+
+//Starts watching configFile and calls reload once no change has been seen for delay; rapid
+//successive writes result in a single reload. The directory holding the file is watched so
+//files replaced by rename, as editors and configuration agents do, are still followed.
+func Watch(configFile string, delay time.Duration, reload func()) (*Watcher, error) {
+	fw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	file := filepath.Clean(configFile)
+	if err := fw.Add(filepath.Dir(file)); err != nil {
+		fw.Close()
+		return nil, err
+	}
+	w := &Watcher{watcher: fw, file: file, delay: delay, reload: reload, done: make(chan struct{})}
+	go w.run()
+	return w, nil
+}
+
+//Stops watching the file and cancels a pending reload.
+func (w *Watcher) Close() error {
+	err := w.watcher.Close()
+	<-w.done
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+	return err
+}
+
+func (w *Watcher) run() {
+	defer close(w.done)
+	for {
+		select {
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			if filepath.Clean(event.Name) != w.file || event.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+				continue
+			}
+			w.schedule()
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Print("proxy-config: watching ", w.file, ": ", err)
+		}
+	}
+}
+
+//Schedules a reload after the delay, postponing any reload already pending.
+func (w *Watcher) schedule() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	w.timer = time.AfterFunc(w.delay, func() {
+		w.lock.Lock()
+		w.timer = nil
+		w.lock.Unlock()
+		w.reload()
+	})
+}
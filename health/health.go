@@ -0,0 +1,278 @@
+package health
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cbergoon/glb/registry"
+)
+
+var ErrInvalidSettings = errors.New("health: path must start with \"/\", durations and thresholds must not be negative and the expected status must be 100-599")
+
+type Settings struct {
+	Path               string //Path requested on each target; defaults to "/".
+	IntervalSeconds    int    //Seconds between checks; defaults to 10.
+	TimeoutSeconds     int    //Seconds before a check is considered failed; defaults to 2.
+	ExpectedStatus     int    //Status code of a healthy response; defaults to 200.
+	HealthyThreshold   int    //Consecutive passing checks to mark a target healthy; defaults to 1.
+	UnhealthyThreshold int    //Consecutive failing checks to mark a target unhealthy; defaults to 1.
+}
+
+type Checker struct {
+	lock     sync.RWMutex                   //Exclusive lock for settings and status.
+	reg      registry.Registry              //Registry providing the targets to check.
+	settings map[string]map[string]Settings //Check settings by service/version.
+	next     map[string]time.Time           //Time of the next check by service/version.
+	status   map[target]*status             //Status by service/version/address.
+	stop     chan struct{}                  //Closed to stop the check loop.
+}
+
+type target struct {
+	Service string
+	Key     string
+	Address string
+}
+
+type status struct {
+	Healthy     bool      //Result of the last state transition.
+	Successes   int       //Consecutive passing checks.
+	Failures    int       //Consecutive failing checks.
+	LastError   error     //Error of the last failing check.
+	LastErrorAt time.Time //Time of the last failing check.
+}
+
+//Creates a health checker for targets in the registry. No targets are checked until
+//settings are provided with Configure and the checker is started.
+func NewChecker(reg registry.Registry) *Checker {
+	return &Checker{
+		reg:      reg,
+		settings: make(map[string]map[string]Settings),
+		next:     make(map[string]time.Time),
+		status:   make(map[target]*status),
+	}
+}
+
+//Replaces the check settings. Service/versions without settings are not checked and their
+//targets are always considered healthy.
+func (c *Checker) Configure(settings map[string]map[string]Settings) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.settings = make(map[string]map[string]Settings)
+	for svc := range settings {
+		c.settings[svc] = make(map[string]Settings)
+		for key, s := range settings[svc] {
+			c.settings[svc][key] = withDefaults(s)
+		}
+	}
+	c.next = make(map[string]time.Time)
+	for t := range c.status {
+		if _, ok := c.settings[t.Service][t.Key]; !ok {
+			delete(c.status, t)
+		}
+	}
+}
+
+//Starts checking targets in the background. Each service/version is checked on its own
+//interval; a one second tick bounds the precision of the interval.
+func (c *Checker) Start() {
+	c.lock.Lock()
+	if c.stop != nil {
+		c.lock.Unlock()
+		return
+	}
+	c.stop = make(chan struct{})
+	stop := c.stop
+	c.lock.Unlock()
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			c.checkDue(time.Now())
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+//Stops the background checks started by Start.
+func (c *Checker) Stop() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.stop != nil {
+		close(c.stop)
+		c.stop = nil
+	}
+}
+
+//Reports whether the target should receive traffic. Targets that are not checked or have
+//not completed a check are considered healthy.
+func (c *Checker) Healthy(svcValue string, keyValue string, address string) bool {
+	if c == nil {
+		return true
+	}
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	st, ok := c.status[target{svcValue, keyValue, address}]
+	if !ok {
+		return true
+	}
+	return st.Healthy
+}
+
+//Returns the description of the last failing check of the target and its time. Returns an
+//empty description if the target has not failed a check.
+func (c *Checker) LastError(svcValue string, keyValue string, address string) (string, time.Time) {
+	if c == nil {
+		return "", time.Time{}
+	}
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	st, ok := c.status[target{svcValue, keyValue, address}]
+	if !ok || st.LastError == nil {
+		return "", time.Time{}
+	}
+	return st.LastError.Error(), st.LastErrorAt
+}
+
+//Runs a single round of checks against every target of service/version and waits for
+//the results. Does nothing if the service/version has no settings.
+func (c *Checker) Probe(svcValue string, keyValue string) {
+	c.lock.RLock()
+	s, ok := c.settings[svcValue][keyValue]
+	c.lock.RUnlock()
+	if !ok {
+		return
+	}
+	targets, err := c.reg.Lookup(svcValue, keyValue)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	client := &http.Client{Timeout: time.Duration(s.TimeoutSeconds) * time.Second}
+	results := make([]error, len(targets))
+	var wg sync.WaitGroup
+	for i := range targets {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = check(client, targets[i].Address, s)
+		}(i)
+	}
+	wg.Wait()
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	seen := make(map[target]bool)
+	for i := range targets {
+		t := target{svcValue, keyValue, targets[i].Address}
+		seen[t] = true
+		st, ok := c.status[t]
+		if !ok {
+			st = &status{Healthy: true}
+			c.status[t] = st
+		}
+		st.record(results[i], s)
+	}
+	for t := range c.status {
+		if t.Service == svcValue && t.Key == keyValue && !seen[t] {
+			delete(c.status, t)
+		}
+	}
+}
+
+//Checks each service/version whose interval has elapsed.
+func (c *Checker) checkDue(now time.Time) {
+	var due [][2]string
+	c.lock.Lock()
+	for svc := range c.settings {
+		for key, s := range c.settings[svc] {
+			k := svc + "/" + key
+			if now.Before(c.next[k]) {
+				continue
+			}
+			c.next[k] = now.Add(time.Duration(s.IntervalSeconds) * time.Second)
+			due = append(due, [2]string{svc, key})
+		}
+	}
+	c.lock.Unlock()
+	for _, d := range due {
+		go c.Probe(d[0], d[1])
+	}
+}
+
+//Records the result of a check transitioning the target once a threshold is met.
+func (st *status) record(err error, s Settings) {
+	if err == nil {
+		st.Successes++
+		st.Failures = 0
+		if !st.Healthy && st.Successes >= s.HealthyThreshold {
+			st.Healthy = true
+		}
+		return
+	}
+	st.Failures++
+	st.Successes = 0
+	st.LastError = err
+	st.LastErrorAt = time.Now()
+	if st.Healthy && st.Failures >= s.UnhealthyThreshold {
+		st.Healthy = false
+	}
+}
+
+//Requests the check path on address and compares the response status with the expected
+//status. Returns an error describing the failure if the check does not pass.
+func check(client *http.Client, address string, s Settings) error {
+	resp, err := client.Get("http://" + address + s.Path)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != s.ExpectedStatus {
+		return fmt.Errorf("health: %s returned status %d; expected %d", address, resp.StatusCode, s.ExpectedStatus)
+	}
+	return nil
+}
+
+//Reports whether the settings can be applied. Returns ErrInvalidSettings if the path is not
+//absolute, a duration or threshold is negative or the expected status is not a status code.
+func (s Settings) Validate() error {
+	if s.Path != "" && s.Path[0] != '/' {
+		return ErrInvalidSettings
+	}
+	if s.IntervalSeconds < 0 || s.TimeoutSeconds < 0 || s.HealthyThreshold < 0 || s.UnhealthyThreshold < 0 {
+		return ErrInvalidSettings
+	}
+	if s.ExpectedStatus != 0 && (s.ExpectedStatus < 100 || s.ExpectedStatus > 599) {
+		return ErrInvalidSettings
+	}
+	return nil
+}
+
+func withDefaults(s Settings) Settings {
+	if s.Path == "" {
+		s.Path = "/"
+	}
+	if s.IntervalSeconds <= 0 {
+		s.IntervalSeconds = 10
+	}
+	if s.TimeoutSeconds <= 0 {
+		s.TimeoutSeconds = 2
+	}
+	if s.ExpectedStatus == 0 {
+		s.ExpectedStatus = http.StatusOK
+	}
+	if s.HealthyThreshold <= 0 {
+		s.HealthyThreshold = 1
+	}
+	if s.UnhealthyThreshold <= 0 {
+		s.UnhealthyThreshold = 1
+	}
+	return s
+}
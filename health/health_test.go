@@ -0,0 +1,67 @@
+package health_test
+
+import (
+	"github.com/cbergoon/glb/health"
+	"github.com/cbergoon/glb/registry"
+	"github.com/cbergoon/glb/registry/standardregistry"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestChecker_Probe(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/health" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer up.Close()
+	status := http.StatusServiceUnavailable
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer down.Close()
+	upAddr := strings.TrimPrefix(up.URL, "http://")
+	downAddr := strings.TrimPrefix(down.URL, "http://")
+
+	sr := &serviceregistry.StandardRegistry{}
+	sr.Add("testSvc01", "testKey01", registry.Target{Address: upAddr})
+	sr.Add("testSvc01", "testKey01", registry.Target{Address: downAddr})
+	sr.Add("testSvc02", "testKey02", registry.Target{Address: downAddr})
+
+	c := health.NewChecker(sr)
+	c.Configure(map[string]map[string]health.Settings{
+		"testSvc01": {"testKey01": {Path: "/health", HealthyThreshold: 2}},
+	})
+	if !c.Healthy("testSvc01", "testKey01", downAddr) {
+		t.Error("Expected unchecked target to be healthy")
+	}
+	c.Probe("testSvc01", "testKey01")
+	if !c.Healthy("testSvc01", "testKey01", upAddr) {
+		t.Error("Expected target to be healthy ", upAddr)
+	}
+	if c.Healthy("testSvc01", "testKey01", downAddr) {
+		t.Error("Expected target to be unhealthy ", downAddr)
+	}
+	if message, at := c.LastError("testSvc01", "testKey01", downAddr); !strings.Contains(message, "returned status 503") || at.IsZero() {
+		t.Error("Expected last error of failed check got ", message, at)
+	}
+	if message, _ := c.LastError("testSvc01", "testKey01", upAddr); message != "" {
+		t.Error("Expected no last error for passing target got ", message)
+	}
+	c.Probe("testSvc02", "testKey02")
+	if !c.Healthy("testSvc02", "testKey02", downAddr) {
+		t.Error("Expected target without settings to be healthy ", downAddr)
+	}
+
+	status = http.StatusOK
+	c.Probe("testSvc01", "testKey01")
+	if c.Healthy("testSvc01", "testKey01", downAddr) {
+		t.Error("Expected target to be unhealthy before threshold ", downAddr)
+	}
+	c.Probe("testSvc01", "testKey01")
+	if !c.Healthy("testSvc01", "testKey01", downAddr) {
+		t.Error("Expected target to recover ", downAddr)
+	}
+}
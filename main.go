@@ -1,15 +1,32 @@
 package main
 
 import (
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
 
 	"os"
 
+	"github.com/cbergoon/glb/accesslog"
+	"github.com/cbergoon/glb/admin"
+	"github.com/cbergoon/glb/balancer"
+	"github.com/cbergoon/glb/breaker"
 	"github.com/cbergoon/glb/config"
+	"github.com/cbergoon/glb/health"
+	"github.com/cbergoon/glb/metrics"
+	"github.com/cbergoon/glb/outlier"
 	"github.com/cbergoon/glb/proxy"
+	"github.com/cbergoon/glb/registry"
+	"github.com/cbergoon/glb/registry/buntregistry"
 	"github.com/cbergoon/glb/registry/standardregistry"
+	"github.com/cbergoon/glb/tracing"
 )
 
 const (
@@ -18,13 +35,150 @@ const (
 	KEY_FILE    = "server.key" //SSL Key
 )
 
-var serviceRegistry *serviceregistry.StandardRegistry = &serviceregistry.StandardRegistry{} //Service registry to store service-address mappings.
-var BasicProxy bool = false                                                                 //Enable single service "default" service/version. Removes requirement of service/version in URL.
-var IdleConnTimeoutSeconds int = 1                                                          //Duration the transport should keep connections alive. Zero imposes no limit.
-var DisableKeepAlives bool = false                                                          //Do not keep alive, reconnect on each request.
+var serviceRegistry *registry.AtomicRegistry //Service registry to store service-address mappings; swapped on reload.
+var storage config.Storage                   //Storage the registry was opened with.
+var reloadLock sync.Mutex                    //Serializes reloads from the admin endpoint, file watcher and SIGHUP with admin API changes.
+var healthChecker *health.Checker            //Active health checker for registry targets.
+var balancers *balancer.Selector             //Load balancing strategy of each service/version.
+var breakers *breaker.Breakers               //Circuit breaker of each target.
+var outlierDetector *outlier.Detector        //Success rate and latency outlier detection.
+var authenticator *admin.Authenticator       //Credentials and roles of the management endpoints.
+var proxyMetrics *metrics.Metrics            //Traffic and reload counters exported on /metrics.
+var accessLog *accesslog.Logger              //Access log of proxied requests.
+var tracer *tracing.Tracer                   //Request ID and trace context propagation.
+var router *proxy.Router                     //Resolves the service/version of each request.
+var BasicProxy atomic.Bool                   //Enable single service "default" service/version. Removes requirement of service/version in URL.
+var IdleConnTimeoutSeconds atomic.Int64      //Duration the transport should keep connections alive. Zero imposes no limit.
+var DisableKeepAlives atomic.Bool            //Do not keep alive, reconnect on each request.
 
-//Starts load balancer, redirect for HTTPS and, service endpoints.
-func runLoadBalancer(addr, port, sslPort string) {
+//Creates the registry described by the storage configuration. A persistent registry is only
+//seeded from the configuration file when it is empty; otherwise the stored targets, failure
+//counts and round robbin counters are used as is.
+func openRegistry(cfg config.ProxyConfig) (registry.Registry, error) {
+	switch cfg.Storage.Type {
+	case "", "standard":
+		r := &serviceregistry.StandardRegistry{}
+		config.PopulateRegistry(cfg, r)
+		return r, nil
+	case "bunt":
+		r, err := buntregistry.Open(cfg.Storage.Path)
+		if err != nil {
+			return nil, err
+		}
+		if r.Empty() {
+			config.PopulateRegistry(cfg, r)
+		} else {
+			log.Print("Using persisted registry from ", cfg.Storage.Path)
+		}
+		return r, nil
+	}
+	return nil, fmt.Errorf("glb: unknown storage type %q", cfg.Storage.Type)
+}
+
+//Reads, validates and applies the configuration file. The in memory registry is rebuilt from
+//the file and swapped in atomically so requests see either the old or the new targets, never a
+//mix. A persistent registry is kept as is; its targets are managed through the admin API.
+//Routing is compiled and the access log opened before anything is applied, so nothing is
+//applied and the error is returned if the file cannot be read, is invalid or the access log
+//cannot be opened.
+func reload() error {
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+	cfg, err := config.ReadConfig(CONFIG_FILE)
+	if err != nil {
+		return err
+	}
+	if cfg.Storage != storage {
+		return errors.New("glb: storage changes require a restart")
+	}
+	table, err := proxy.CompileRouting(cfg.Routing, cfg.VirtualHosts, cfg.Routes, cfg.Splits, cfg.Mirrors)
+	if err != nil {
+		return err
+	}
+	balancerTable, err := balancer.Compile(cfg.Balancers())
+	if err != nil {
+		return err
+	}
+	credentials, err := admin.CompileCredentials(cfg.Admin)
+	if err != nil {
+		return err
+	}
+	//Opened last as it is the only step holding a resource that would need closing on error.
+	output, err := accesslog.Open(cfg.AccessLog)
+	if err != nil {
+		return err
+	}
+	//Nothing below can fail, so a reload applies all of the configuration or none of it.
+	accessLog.Apply(output)
+	tracer.Configure(cfg.Tracing)
+	if storage.Type == "" || storage.Type == "standard" {
+		r := &serviceregistry.StandardRegistry{}
+		config.PopulateRegistry(cfg, r)
+		serviceRegistry.Swap(r)
+	} else {
+		log.Print("Keeping persisted registry from ", storage.Path)
+	}
+	router.Apply(table)
+	BasicProxy.Store(cfg.Basic)
+	IdleConnTimeoutSeconds.Store(int64(cfg.IdleConnTimeoutSeconds))
+	DisableKeepAlives.Store(cfg.DisableKeepAlives)
+	healthChecker.Configure(cfg.HealthChecks())
+	breakers.Configure(cfg.CircuitBreakers())
+	outlierDetector.Configure(cfg.OutlierDetectors())
+	balancers.Apply(balancerTable)
+	authenticator.Apply(credentials)
+	return nil
+}
+
+//Reloads the configuration and logs the outcome; trigger names what caused the reload.
+func reloadAndLog(trigger string) error {
+	err := reload()
+	proxyMetrics.Reload(err == nil)
+	if err != nil {
+		log.Print("Reload on ", trigger, " rejected: ", err)
+	} else {
+		log.Print("Reload on ", trigger, " applied configuration from ", CONFIG_FILE)
+	}
+	return err
+}
+
+//Reloads the configuration when the file changes or the process receives SIGHUP.
+func watchConfig() {
+	if _, err := config.Watch(CONFIG_FILE, config.DEBOUNCE_DELAY, func() { reloadAndLog("file change") }); err != nil {
+		log.Print("Not watching ", CONFIG_FILE, ": ", err)
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			reloadAndLog("SIGHUP")
+		}
+	}()
+}
+
+//Closes a persistent registry when the process receives SIGINT or SIGTERM so that its round
+//robbin counters are written before exiting.
+func closeOnExit(r registry.Registry) {
+	c, ok := r.(io.Closer)
+	if !ok {
+		return
+	}
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-stop
+		if err := c.Close(); err != nil {
+			log.Print(err)
+		}
+		os.Exit(0)
+	}()
+}
+
+//Starts load balancer, redirect for HTTPS and, service endpoints. The service endpoints are
+//served on their own listener at adminAddr so they neither shadow service names nor are
+//exposed on the public port; they are disabled if adminAddr is blank. The admin listener
+//serves HTTPS if adminTLS is not nil.
+func runLoadBalancer(addr, port, sslPort, adminAddr string, adminTLS *tls.Config) {
 	//Redirect to HTTPS
 	if sslPort != "" {
 		log.Print("HTTPS config specified; starting HTTP redirect server.")
@@ -37,43 +191,110 @@ func runLoadBalancer(addr, port, sslPort string) {
 		log.Print("HTTP only config specified; not starting HTTP redirect server.")
 	}
 	//GLB Service Endpoints
-	http.HandleFunc("/status", func(w http.ResponseWriter, req *http.Request) {
-		fmt.Fprintf(w, "%v\n", *serviceRegistry)
-	})
-	http.HandleFunc("/reload", func(w http.ResponseWriter, req *http.Request) {
-		config, err := config.ReadParseConfig(CONFIG_FILE, serviceRegistry)
-		if err != nil {
-			log.Print(err)
-			os.Exit(-1)
-		}
-		BasicProxy = config.Basic
-		IdleConnTimeoutSeconds = config.IdleConnTimeoutSeconds
-		DisableKeepAlives = config.DisableKeepAlives
-		fmt.Fprintf(w, "%v\n", *serviceRegistry)
-	})
+	if adminAddr != "" {
+		adminMux := http.NewServeMux()
+		api := admin.NewAPI(serviceRegistry, healthChecker, balancers, breakers, outlierDetector, storage.Type == "bunt", &reloadLock)
+		adminMux.Handle("/status", authenticator.Protect(http.HandlerFunc(api.ServeStatus)))
+		adminMux.Handle("/metrics", authenticator.Protect(proxyMetrics))
+		adminMux.Handle("/dashboard", authenticator.Protect(http.HandlerFunc(api.ServeDashboard)))
+		adminMux.Handle(admin.PREFIX, authenticator.Protect(api))
+		adminMux.Handle(admin.PREFIX+"/", authenticator.Protect(api))
+		routes := admin.NewRouteTester(router, &BasicProxy)
+		adminMux.Handle(admin.ROUTES_PREFIX, authenticator.ProtectRead(routes))
+		adminMux.Handle(admin.ROUTES_PREFIX+"/", authenticator.ProtectRead(routes))
+		splits := admin.NewSplitEditor(router)
+		adminMux.Handle(admin.SPLITS_PREFIX, authenticator.Protect(splits))
+		adminMux.Handle(admin.SPLITS_PREFIX+"/", authenticator.Protect(splits))
+		adminMux.Handle("/reload", authenticator.ProtectWrite(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if err := reloadAndLog("/reload"); err != nil {
+				http.Error(w, "reload rejected: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			api.ServeStatus(w, req)
+		})))
+		server := &http.Server{Addr: adminAddr, Handler: adminMux, TLSConfig: adminTLS}
+		go func() {
+			if adminTLS != nil {
+				log.Print("Admin address specified; serving management endpoints over HTTPS on ", adminAddr)
+				log.Fatal(server.ListenAndServeTLS("", ""))
+			}
+			log.Print("Admin address specified; serving management endpoints on ", adminAddr)
+			log.Fatal(server.ListenAndServe())
+		}()
+	} else {
+		log.Print("No admin address specified; management endpoints disabled")
+	}
 	//Proxy Endpoint
-	http.HandleFunc("/", proxy.NewLoadBalanceHostReverseProxy(serviceRegistry, &BasicProxy, &IdleConnTimeoutSeconds, &DisableKeepAlives))
+	proxyMux := http.NewServeMux()
+	proxyMux.HandleFunc("/", proxy.NewLoadBalanceHostReverseProxy(serviceRegistry, healthChecker, balancers, breakers, outlierDetector, proxyMetrics, accessLog, tracer, router, &BasicProxy, &IdleConnTimeoutSeconds, &DisableKeepAlives))
 	if sslPort != "" {
 		log.Print("HTTPS config specified; listen and serve HTTPS")
 		log.Print("Using Certificate File: ", CERT_FILE, " and Key File: ", KEY_FILE)
-		log.Fatal(http.ListenAndServeTLS(sslPort, CERT_FILE, KEY_FILE, nil))
+		log.Fatal(http.ListenAndServeTLS(sslPort, CERT_FILE, KEY_FILE, proxyMux))
 	} else {
 		log.Print("HTTP only config specified; listen and serve HTTP")
-		log.Fatal(http.ListenAndServe(sslPort, nil))
+		log.Fatal(http.ListenAndServe(port, proxyMux))
 	}
 }
 
 //Application entry point gets configuration and starts the load balancer.
 func main() {
 	//Configure
-	config, err := config.ReadParseConfig(CONFIG_FILE, serviceRegistry)
+	config, err := config.ReadConfig(CONFIG_FILE)
+	if err != nil {
+		log.Print(err)
+		os.Exit(-1)
+	}
+	r, err := openRegistry(config)
+	if err != nil {
+		log.Print(err)
+		os.Exit(-1)
+	}
+	serviceRegistry = registry.NewAtomicRegistry(r)
+	closeOnExit(r)
+	storage = config.Storage
+	BasicProxy.Store(config.Basic)
+	IdleConnTimeoutSeconds.Store(int64(config.IdleConnTimeoutSeconds))
+	DisableKeepAlives.Store(config.DisableKeepAlives)
+	healthChecker = health.NewChecker(serviceRegistry)
+	healthChecker.Configure(config.HealthChecks())
+	healthChecker.Start()
+	balancers = balancer.NewSelector(serviceRegistry)
+	if err := balancers.Configure(config.Balancers()); err != nil {
+		log.Print(err)
+		os.Exit(-1)
+	}
+	breakers = breaker.NewBreakers(serviceRegistry)
+	breakers.Configure(config.CircuitBreakers())
+	outlierDetector = outlier.NewDetector(serviceRegistry)
+	outlierDetector.Configure(config.OutlierDetectors())
+	outlierDetector.Start()
+	proxyMetrics = metrics.NewMetrics(serviceRegistry, balancers)
+	accessLog = accesslog.NewLogger()
+	if err := accessLog.Configure(config.AccessLog); err != nil {
+		log.Print(err)
+		os.Exit(-1)
+	}
+	tracer = tracing.NewTracer()
+	tracer.Configure(config.Tracing)
+	router = proxy.NewRouter(serviceRegistry)
+	table, err := proxy.CompileRouting(config.Routing, config.VirtualHosts, config.Routes, config.Splits, config.Mirrors)
+	if err != nil {
+		log.Print(err)
+		os.Exit(-1)
+	}
+	router.Apply(table)
+	authenticator = admin.NewAuthenticator()
+	if err := authenticator.Configure(config.Admin); err != nil {
+		log.Print(err)
+		os.Exit(-1)
+	}
+	adminTLS, err := config.Admin.TLSConfig()
 	if err != nil {
 		log.Print(err)
 		os.Exit(-1)
 	}
-	BasicProxy = config.Basic
-	IdleConnTimeoutSeconds = config.IdleConnTimeoutSeconds
-	DisableKeepAlives = config.DisableKeepAlives
+	watchConfig()
 	//Run
-	runLoadBalancer(config.Host.Addr, config.Host.Port, config.Host.SslPort)
+	runLoadBalancer(config.Host.Addr, config.Host.Port, config.Host.SslPort, config.Host.AdminAddr, adminTLS)
 }
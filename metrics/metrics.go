@@ -0,0 +1,292 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cbergoon/glb/balancer"
+	"github.com/cbergoon/glb/registry"
+)
+
+//Upper bounds in seconds of the request latency histogram buckets.
+var BUCKETS = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+//Proxy traffic counters exported in the Prometheus text format. Counters are kept per target
+//by the proxy; gauges are read from the registry and balancer counters when scraped.
+type Metrics struct {
+	lock            sync.Mutex            //Exclusive lock for counters.
+	reg             registry.Registry     //Registry providing the target count of each service/version.
+	balancers       *balancer.Selector    //Selector providing in-flight requests; may be nil.
+	requests        map[request]uint64    //Responses by service/version/address/status code.
+	latencies       map[target]*histogram //Latency of responses by service/version/address.
+	shadowRequests  map[request]uint64    //Responses to mirrored requests by service/version/address/status code.
+	shadowLatencies map[target]*histogram //Latency of responses to mirrored requests by service/version/address.
+	dialErrors      map[target]uint64     //Failed dials by service/version/address.
+	noTarget        map[[2]string]uint64  //Requests without an available target by service/version.
+	shadowDial      map[target]uint64     //Failed dials of mirrored requests by service/version/address.
+	shadowNoTarget  map[[2]string]uint64  //Mirrored requests without an available target by service/version.
+	reloads         map[string]uint64     //Configuration reloads by result; success or failure.
+}
+
+type target struct {
+	Service string
+	Key     string
+	Address string
+}
+
+type request struct {
+	target
+	Code string
+}
+
+type histogram struct {
+	counts []uint64 //Observations per bucket of BUCKETS; not cumulative.
+	sum    float64  //Sum of observations in seconds.
+	count  uint64   //Number of observations.
+}
+
+//Creates metrics for the proxy in front of reg. The balancers provide the in-flight requests
+//gauge and may be nil.
+func NewMetrics(reg registry.Registry, balancers *balancer.Selector) *Metrics {
+	return &Metrics{
+		reg:             reg,
+		balancers:       balancers,
+		requests:        make(map[request]uint64),
+		latencies:       make(map[target]*histogram),
+		shadowRequests:  make(map[request]uint64),
+		shadowLatencies: make(map[target]*histogram),
+		dialErrors:      make(map[target]uint64),
+		noTarget:        make(map[[2]string]uint64),
+		shadowDial:      make(map[target]uint64),
+		shadowNoTarget:  make(map[[2]string]uint64),
+		reloads:         make(map[string]uint64),
+	}
+}
+
+//Records a request forwarded to the target. The code is the status of the response or zero
+//if no response was received, exported as code="error". Latency is recorded for responses.
+func (m *Metrics) ObserveRequest(svcValue string, keyValue string, address string, code int, latency time.Duration) {
+	if m == nil {
+		return
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	observe(m.requests, m.latencies, target{svcValue, keyValue, address}, code, latency)
+}
+
+//Records a mirrored request forwarded to the target as ObserveRequest does, in separate series
+//so shadow traffic can be compared with the traffic clients receive responses from.
+func (m *Metrics) ObserveShadow(svcValue string, keyValue string, address string, code int, latency time.Duration) {
+	if m == nil {
+		return
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	observe(m.shadowRequests, m.shadowLatencies, target{svcValue, keyValue, address}, code, latency)
+}
+
+func observe(requests map[request]uint64, latencies map[target]*histogram, t target, code int, latency time.Duration) {
+	label := "error"
+	if code > 0 {
+		label = strconv.Itoa(code)
+	}
+	requests[request{t, label}]++
+	if code == 0 {
+		return
+	}
+	h, ok := latencies[t]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(BUCKETS))}
+		latencies[t] = h
+	}
+	seconds := latency.Seconds()
+	for i, bound := range BUCKETS {
+		if seconds <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+//Records a failed dial to the target.
+func (m *Metrics) DialError(svcValue string, keyValue string, address string) {
+	if m == nil {
+		return
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.dialErrors[target{svcValue, keyValue, address}]++
+}
+
+//Records a request to service/version that found no available target.
+func (m *Metrics) NoTarget(svcValue string, keyValue string) {
+	if m == nil {
+		return
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.noTarget[[2]string{svcValue, keyValue}]++
+}
+
+//Records a failed dial to the target of a mirrored request.
+func (m *Metrics) ShadowDialError(svcValue string, keyValue string, address string) {
+	if m == nil {
+		return
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.shadowDial[target{svcValue, keyValue, address}]++
+}
+
+//Records a mirrored request to service/version that found no available target.
+func (m *Metrics) ShadowNoTarget(svcValue string, keyValue string) {
+	if m == nil {
+		return
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.shadowNoTarget[[2]string{svcValue, keyValue}]++
+}
+
+//Records the result of a configuration reload.
+func (m *Metrics) Reload(success bool) {
+	if m == nil {
+		return
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if success {
+		m.reloads["success"]++
+	} else {
+		m.reloads["failure"]++
+	}
+}
+
+//Serves the metrics in the Prometheus text exposition format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.Write(w)
+}
+
+//Writes the metrics in the Prometheus text exposition format. Series are sorted by labels so
+//the output is stable between scrapes.
+func (m *Metrics) Write(w io.Writer) {
+	var sizes []string
+	for _, svc := range m.reg.ServiceNames() {
+		keys, _ := m.reg.KeyNames(svc)
+		for _, key := range keys {
+			targets, err := m.reg.Lookup(svc, key)
+			if err != nil {
+				continue
+			}
+			sizes = append(sizes, fmt.Sprintf("glb_registry_targets{%s} %d", labels("service", svc, "version", key), len(targets)))
+		}
+	}
+	var inFlight []string
+	if m.balancers != nil {
+		for svc, keys := range m.balancers.Requests.Snapshot() {
+			for key, addresses := range keys {
+				for address, count := range addresses {
+					inFlight = append(inFlight, fmt.Sprintf("glb_requests_in_flight{%s} %d", labels("service", svc, "version", key, "target", address), count))
+				}
+			}
+		}
+	}
+
+	m.lock.Lock()
+	var dialErrors, noTarget, shadowDial, shadowNoTarget, reloads []string
+	requests := requestSeries("glb_requests_total", m.requests)
+	latencies := latencySeries("glb_request_duration_seconds", m.latencies)
+	shadowRequests := requestSeries("glb_shadow_requests_total", m.shadowRequests)
+	shadowLatencies := latencySeries("glb_shadow_request_duration_seconds", m.shadowLatencies)
+	for t, count := range m.dialErrors {
+		dialErrors = append(dialErrors, fmt.Sprintf("glb_dial_errors_total{%s} %d", labels("service", t.Service, "version", t.Key, "target", t.Address), count))
+	}
+	for k, count := range m.noTarget {
+		noTarget = append(noTarget, fmt.Sprintf("glb_no_target_total{%s} %d", labels("service", k[0], "version", k[1]), count))
+	}
+	for t, count := range m.shadowDial {
+		shadowDial = append(shadowDial, fmt.Sprintf("glb_shadow_dial_errors_total{%s} %d", labels("service", t.Service, "version", t.Key, "target", t.Address), count))
+	}
+	for k, count := range m.shadowNoTarget {
+		shadowNoTarget = append(shadowNoTarget, fmt.Sprintf("glb_shadow_no_target_total{%s} %d", labels("service", k[0], "version", k[1]), count))
+	}
+	for _, result := range []string{"success", "failure"} {
+		reloads = append(reloads, fmt.Sprintf("glb_reloads_total{%s} %d", labels("result", result), m.reloads[result]))
+	}
+	m.lock.Unlock()
+
+	family(w, "glb_requests_total", "counter", "Responses received from targets by status code; code is \"error\" if no response was received.", requests)
+	family(w, "glb_request_duration_seconds", "histogram", "Time from forwarding a request to receiving the response headers.", latencies)
+	family(w, "glb_shadow_requests_total", "counter", "Responses received from targets of mirrored requests by status code; the responses are discarded.", shadowRequests)
+	family(w, "glb_shadow_request_duration_seconds", "histogram", "Time from forwarding a mirrored request to receiving the response headers.", shadowLatencies)
+	family(w, "glb_dial_errors_total", "counter", "Connections to targets that could not be established.", dialErrors)
+	family(w, "glb_no_target_total", "counter", "Requests that found no available target.", noTarget)
+	family(w, "glb_shadow_dial_errors_total", "counter", "Connections to targets of mirrored requests that could not be established.", shadowDial)
+	family(w, "glb_shadow_no_target_total", "counter", "Mirrored requests that found no available target.", shadowNoTarget)
+	family(w, "glb_requests_in_flight", "gauge", "Requests forwarded to targets whose response has not completed.", inFlight)
+	family(w, "glb_registry_targets", "gauge", "Targets registered for each service/version.", sizes)
+	family(w, "glb_reloads_total", "counter", "Configuration reloads by result.", reloads)
+}
+
+//Formats the request counters as series of the named counter.
+func requestSeries(name string, requests map[request]uint64) []string {
+	var series []string
+	for r, count := range requests {
+		series = append(series, fmt.Sprintf("%s{%s} %d", name, labels("service", r.Service, "version", r.Key, "target", r.Address, "code", r.Code), count))
+	}
+	return series
+}
+
+//Formats the latency histograms as series of the named histogram.
+func latencySeries(name string, latencies map[target]*histogram) []string {
+	var all []string
+	for t, h := range latencies {
+		l := labels("service", t.Service, "version", t.Key, "target", t.Address)
+		var cumulative uint64
+		var series []string
+		for i, bound := range BUCKETS {
+			cumulative += h.counts[i]
+			series = append(series, fmt.Sprintf("%s_bucket{%s,le=\"%s\"} %d", name, l, strconv.FormatFloat(bound, 'g', -1, 64), cumulative))
+		}
+		series = append(series,
+			fmt.Sprintf("%s_bucket{%s,le=\"+Inf\"} %d", name, l, h.count),
+			fmt.Sprintf("%s_sum{%s} %s", name, l, strconv.FormatFloat(h.sum, 'g', -1, 64)),
+			fmt.Sprintf("%s_count{%s} %d", name, l, h.count))
+		//Keep the series of a target together and in bucket order when sorting.
+		all = append(all, strings.Join(series, "\n"))
+	}
+	return all
+}
+
+//Writes the help and type of a metric family followed by its sorted series.
+func family(w io.Writer, name string, kind string, help string, series []string) {
+	sort.Strings(series)
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	for _, s := range series {
+		fmt.Fprintln(w, s)
+	}
+}
+
+//Formats name/value pairs as Prometheus labels escaping the values.
+func labels(pairs ...string) string {
+	l := make([]string, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		l = append(l, pairs[i]+"=\""+escape(pairs[i+1])+"\"")
+	}
+	return strings.Join(l, ",")
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escape(value string) string {
+	return escaper.Replace(value)
+}
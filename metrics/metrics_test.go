@@ -0,0 +1,72 @@
+package metrics_test
+
+import (
+	"bytes"
+	"github.com/cbergoon/glb/balancer"
+	"github.com/cbergoon/glb/metrics"
+	"github.com/cbergoon/glb/registry"
+	"github.com/cbergoon/glb/registry/standardregistry"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	sr := &serviceregistry.StandardRegistry{}
+	sr.Add("testSvc01", "testKey01", registry.Target{Address: "localhost:8080"})
+	sr.Add("testSvc01", "testKey01", registry.Target{Address: "localhost:8081"})
+	sr.Add("test\"Svc02", "testKey01", registry.Target{Address: "localhost:8082"})
+	balancers := balancer.NewSelector(sr)
+	balancers.Requests.Increment("testSvc01", "testKey01", "localhost:8080")
+	m := metrics.NewMetrics(sr, balancers)
+	m.ObserveRequest("testSvc01", "testKey01", "localhost:8080", 200, 20*time.Millisecond)
+	m.ObserveRequest("testSvc01", "testKey01", "localhost:8080", 200, 3*time.Second)
+	m.ObserveRequest("testSvc01", "testKey01", "localhost:8080", 502, 20*time.Second)
+	m.ObserveRequest("testSvc01", "testKey01", "localhost:8081", 0, time.Second)
+	m.ObserveShadow("testSvc01", "testKey02", "localhost:9090", 500, 30*time.Millisecond)
+	m.DialError("testSvc01", "testKey01", "localhost:8081")
+	m.NoTarget("testSvc01", "testKey01")
+	m.ShadowDialError("testSvc01", "testKey02", "localhost:9091")
+	m.ShadowNoTarget("testSvc01", "testKey03")
+	m.Reload(true)
+	m.Reload(false)
+	m.Reload(true)
+
+	var buf bytes.Buffer
+	m.Write(&buf)
+	out := buf.String()
+	for _, line := range []string{
+		"# TYPE glb_requests_total counter",
+		`glb_requests_total{service="testSvc01",version="testKey01",target="localhost:8080",code="200"} 2`,
+		`glb_requests_total{service="testSvc01",version="testKey01",target="localhost:8080",code="502"} 1`,
+		`glb_requests_total{service="testSvc01",version="testKey01",target="localhost:8081",code="error"} 1`,
+		"# TYPE glb_request_duration_seconds histogram",
+		`glb_request_duration_seconds_bucket{service="testSvc01",version="testKey01",target="localhost:8080",le="0.025"} 1`,
+		`glb_request_duration_seconds_bucket{service="testSvc01",version="testKey01",target="localhost:8080",le="5"} 2`,
+		`glb_request_duration_seconds_bucket{service="testSvc01",version="testKey01",target="localhost:8080",le="10"} 2`,
+		`glb_request_duration_seconds_bucket{service="testSvc01",version="testKey01",target="localhost:8080",le="+Inf"} 3`,
+		`glb_request_duration_seconds_count{service="testSvc01",version="testKey01",target="localhost:8080"} 3`,
+		`glb_shadow_requests_total{service="testSvc01",version="testKey02",target="localhost:9090",code="500"} 1`,
+		`glb_shadow_request_duration_seconds_bucket{service="testSvc01",version="testKey02",target="localhost:9090",le="0.05"} 1`,
+		`glb_shadow_request_duration_seconds_count{service="testSvc01",version="testKey02",target="localhost:9090"} 1`,
+		`glb_dial_errors_total{service="testSvc01",version="testKey01",target="localhost:8081"} 1`,
+		`glb_no_target_total{service="testSvc01",version="testKey01"} 1`,
+		`glb_shadow_dial_errors_total{service="testSvc01",version="testKey02",target="localhost:9091"} 1`,
+		`glb_shadow_no_target_total{service="testSvc01",version="testKey03"} 1`,
+		`glb_requests_in_flight{service="testSvc01",version="testKey01",target="localhost:8080"} 1`,
+		`glb_registry_targets{service="testSvc01",version="testKey01"} 2`,
+		`glb_registry_targets{service="test\"Svc02",version="testKey01"} 1`,
+		`glb_reloads_total{result="success"} 2`,
+		`glb_reloads_total{result="failure"} 1`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Error("Expected line ", line, " in output got\n", out)
+		}
+	}
+	if strings.Contains(out, `glb_requests_total{service="testSvc01",version="testKey02"`) || strings.Contains(out, `glb_dial_errors_total{service="testSvc01",version="testKey02"`) || strings.Contains(out, `glb_no_target_total{service="testSvc01",version="testKey03"`) {
+		t.Error("Expected shadow traffic to be kept out of glb_requests_total")
+	}
+	if strings.Contains(out, `target="localhost:8081",le=`) {
+		t.Error("Expected no latency for requests without a response")
+	}
+}
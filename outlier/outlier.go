@@ -0,0 +1,345 @@
+package outlier
+
+import (
+	"errors"
+	"log"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cbergoon/glb/registry"
+)
+
+const MAX_SAMPLES = 1000 //Latency samples kept per target per interval.
+
+var ErrInvalidSettings = errors.New("outlier: settings must not be negative and the ejection percent must not exceed 100")
+
+type Settings struct {
+	IntervalSeconds        int     //Seconds between analyses; defaults to 10.
+	BaseEjectionSeconds    int     //Ejection time, times the consecutive ejections; defaults to 30.
+	MaxEjectionPercent     int     //Share of targets ejected at once, at least one; defaults to 10.
+	MinRequests            int     //Requests in an interval to analyse a target; defaults to 100.
+	MinHosts               int     //Analysed targets needed to eject any; defaults to 3.
+	SuccessRateStdevFactor float64 //Eject below mean - factor * stdev success rate; defaults to 1.9.
+	LatencyFactor          float64 //Eject above factor * the median p99 latency; zero disables.
+	ConsecutiveFailures    int     //Eject once the registry Failures count reaches this; zero disables.
+}
+
+type Detector struct {
+	lock     sync.Mutex                     //Exclusive lock for settings and stats.
+	reg      registry.Registry              //Registry providing the peers of each target.
+	settings map[string]map[string]Settings //Detection settings by service/version.
+	next     map[string]time.Time           //Time of the next analysis by service/version.
+	stats    map[target]*stats              //Stats by service/version/address.
+	stop     chan struct{}                  //Closed to stop the analysis loop.
+}
+
+type target struct {
+	Service string
+	Key     string
+	Address string
+}
+
+type stats struct {
+	Requests     int             //Requests in the current interval.
+	Failures     int             //Failed requests in the current interval.
+	Latencies    []time.Duration //Reservoir of latency samples in the current interval.
+	EjectedUntil time.Time       //Time the ejection ends; zero if not ejected.
+	Ejections    int             //Consecutive intervals the target has been ejected in.
+}
+
+//Creates an outlier detector for targets in the registry. No target is ejected until settings
+//are provided with Configure and the detector is started.
+func NewDetector(reg registry.Registry) *Detector {
+	return &Detector{
+		reg:      reg,
+		settings: make(map[string]map[string]Settings),
+		next:     make(map[string]time.Time),
+		stats:    make(map[target]*stats),
+	}
+}
+
+//Replaces the detection settings. Service/versions without settings are not analysed.
+func (d *Detector) Configure(settings map[string]map[string]Settings) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.settings = make(map[string]map[string]Settings)
+	for svc := range settings {
+		d.settings[svc] = make(map[string]Settings)
+		for key, s := range settings[svc] {
+			d.settings[svc][key] = withDefaults(s)
+		}
+	}
+	d.next = make(map[string]time.Time)
+	for t := range d.stats {
+		if _, ok := d.settings[t.Service][t.Key]; !ok {
+			delete(d.stats, t)
+		}
+	}
+}
+
+//Starts analysing targets in the background on the interval of each service/version.
+func (d *Detector) Start() {
+	d.lock.Lock()
+	if d.stop != nil {
+		d.lock.Unlock()
+		return
+	}
+	d.stop = make(chan struct{})
+	stop := d.stop
+	d.lock.Unlock()
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case now := <-ticker.C:
+				d.analyseDue(now)
+			}
+		}
+	}()
+}
+
+//Stops the background analysis started by Start.
+func (d *Detector) Stop() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.stop != nil {
+		close(d.stop)
+		d.stop = nil
+	}
+}
+
+//Records the outcome and latency of a request to the target. Failures are classified as for
+//the circuit breaker; dial errors, 5xx responses and timeouts.
+func (d *Detector) Record(svcValue string, keyValue string, address string, failed bool, latency time.Duration) {
+	if d == nil {
+		return
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if _, ok := d.settings[svcValue][keyValue]; !ok {
+		return
+	}
+	t := target{svcValue, keyValue, address}
+	st, ok := d.stats[t]
+	if !ok {
+		st = &stats{}
+		d.stats[t] = st
+	}
+	st.Requests++
+	if failed {
+		st.Failures++
+	}
+	if len(st.Latencies) < MAX_SAMPLES {
+		st.Latencies = append(st.Latencies, latency)
+	} else if i := rand.Intn(st.Requests); i < MAX_SAMPLES {
+		st.Latencies[i] = latency
+	}
+}
+
+//Reports whether the target may receive traffic; false while the target is ejected.
+func (d *Detector) Available(svcValue string, keyValue string, address string) bool {
+	if d == nil {
+		return true
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	st, ok := d.stats[target{svcValue, keyValue, address}]
+	return !ok || !time.Now().Before(st.EjectedUntil)
+}
+
+//Analyses the interval that just ended for service/version and ejects outliers. A target is an
+//outlier if its success rate is more than SuccessRateStdevFactor standard deviations below the
+//mean of its peers, if LatencyFactor is set, its p99 latency exceeds LatencyFactor times the
+//median p99 of its peers or, if ConsecutiveFailures is set, the Failures count kept for it in
+//the registry has reached ConsecutiveFailures and it received requests during the interval. At
+//most MaxEjectionPercent of the targets are ejected at once. The stats of every target are reset
+//for the next interval.
+func (d *Detector) Analyse(svcValue string, keyValue string) {
+	targets, err := d.reg.Lookup(svcValue, keyValue)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	s, ok := d.settings[svcValue][keyValue]
+	if !ok {
+		return
+	}
+	now := time.Now()
+	present := make(map[target]bool)
+	ejected := 0
+	var analysed []target
+	for i := range targets {
+		t := target{svcValue, keyValue, targets[i].Address}
+		present[t] = true
+		st, ok := d.stats[t]
+		if !ok {
+			continue
+		}
+		if now.Before(st.EjectedUntil) {
+			ejected++
+		} else if st.Requests >= s.MinRequests {
+			analysed = append(analysed, t)
+		}
+	}
+
+	var outliers []target
+	if s.ConsecutiveFailures > 0 {
+		for i := range targets {
+			t := target{svcValue, keyValue, targets[i].Address}
+			if st, ok := d.stats[t]; ok && st.Requests > 0 && targets[i].Failures >= s.ConsecutiveFailures {
+				outliers = append(outliers, t)
+			}
+		}
+	}
+	if len(analysed) >= s.MinHosts {
+		outliers = append(outliers, d.successRateOutliers(analysed, s)...)
+		if s.LatencyFactor > 0 {
+			outliers = append(outliers, d.latencyOutliers(analysed, s)...)
+		}
+	}
+	max := len(targets) * s.MaxEjectionPercent / 100
+	if max < 1 {
+		max = 1
+	}
+	for _, t := range outliers {
+		st := d.stats[t]
+		if ejected >= max || now.Before(st.EjectedUntil) {
+			continue
+		}
+		st.Ejections++
+		st.EjectedUntil = now.Add(time.Duration(s.BaseEjectionSeconds*st.Ejections) * time.Second)
+		ejected++
+		log.Printf("outlier: ejected %s/%s at %s until %s",
+			svcValue, keyValue, t.Address, st.EjectedUntil.Format(time.RFC3339))
+	}
+
+	for t, st := range d.stats {
+		if t.Service != svcValue || t.Key != keyValue {
+			continue
+		}
+		if !present[t] {
+			delete(d.stats, t)
+			continue
+		}
+		if st.Ejections > 0 && !now.Before(st.EjectedUntil) && st.Requests >= s.MinRequests {
+			st.Ejections = 0
+		}
+		st.Requests, st.Failures, st.Latencies = 0, 0, nil
+	}
+}
+
+//Returns the targets whose success rate is below the mean by more than the configured number
+//of standard deviations.
+func (d *Detector) successRateOutliers(analysed []target, s Settings) []target {
+	rates := make([]float64, len(analysed))
+	mean := 0.0
+	for i, t := range analysed {
+		st := d.stats[t]
+		rates[i] = float64(st.Requests-st.Failures) / float64(st.Requests)
+		mean += rates[i]
+	}
+	mean /= float64(len(rates))
+	variance := 0.0
+	for _, r := range rates {
+		variance += (r - mean) * (r - mean)
+	}
+	threshold := mean - s.SuccessRateStdevFactor*math.Sqrt(variance/float64(len(rates)))
+	var outliers []target
+	for i, t := range analysed {
+		if rates[i] < threshold {
+			outliers = append(outliers, t)
+		}
+	}
+	return outliers
+}
+
+//Returns the targets whose p99 latency exceeds the median p99 by the configured factor.
+func (d *Detector) latencyOutliers(analysed []target, s Settings) []target {
+	p99s := make([]time.Duration, len(analysed))
+	for i, t := range analysed {
+		p99s[i] = percentile(d.stats[t].Latencies, 0.99)
+	}
+	sorted := append([]time.Duration(nil), p99s...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	median := sorted[len(sorted)/2]
+	var outliers []target
+	for i, t := range analysed {
+		if float64(p99s[i]) > s.LatencyFactor*float64(median) {
+			outliers = append(outliers, t)
+		}
+	}
+	return outliers
+}
+
+//Analyses each service/version whose interval has elapsed.
+func (d *Detector) analyseDue(now time.Time) {
+	var due [][2]string
+	d.lock.Lock()
+	for svc := range d.settings {
+		for key, s := range d.settings[svc] {
+			k := svc + "/" + key
+			if d.next[k].IsZero() {
+				d.next[k] = now.Add(time.Duration(s.IntervalSeconds) * time.Second)
+			}
+			if now.Before(d.next[k]) {
+				continue
+			}
+			d.next[k] = now.Add(time.Duration(s.IntervalSeconds) * time.Second)
+			due = append(due, [2]string{svc, key})
+		}
+	}
+	d.lock.Unlock()
+	for _, k := range due {
+		d.Analyse(k[0], k[1])
+	}
+}
+
+func percentile(samples []time.Duration, p float64) time.Duration {
+	if len(samples) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration(nil), samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[int(math.Ceil(p*float64(len(sorted))))-1]
+}
+
+//Reports whether the settings can be applied. Returns ErrInvalidSettings if a value is negative
+//or MaxEjectionPercent exceeds 100.
+func (s Settings) Validate() error {
+	if s.IntervalSeconds < 0 || s.BaseEjectionSeconds < 0 || s.MinRequests < 0 || s.MinHosts < 0 ||
+		s.MaxEjectionPercent < 0 || s.MaxEjectionPercent > 100 ||
+		s.SuccessRateStdevFactor < 0 || s.LatencyFactor < 0 || s.ConsecutiveFailures < 0 {
+		return ErrInvalidSettings
+	}
+	return nil
+}
+
+func withDefaults(s Settings) Settings {
+	if s.IntervalSeconds <= 0 {
+		s.IntervalSeconds = 10
+	}
+	if s.BaseEjectionSeconds <= 0 {
+		s.BaseEjectionSeconds = 30
+	}
+	if s.MaxEjectionPercent <= 0 {
+		s.MaxEjectionPercent = 10
+	}
+	if s.MinRequests <= 0 {
+		s.MinRequests = 100
+	}
+	if s.MinHosts <= 0 {
+		s.MinHosts = 3
+	}
+	if s.SuccessRateStdevFactor <= 0 {
+		s.SuccessRateStdevFactor = 1.9
+	}
+	return s
+}
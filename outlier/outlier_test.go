@@ -0,0 +1,110 @@
+package outlier_test
+
+import (
+	"fmt"
+	"github.com/cbergoon/glb/outlier"
+	"github.com/cbergoon/glb/registry"
+	"github.com/cbergoon/glb/registry/standardregistry"
+	"testing"
+	"time"
+)
+
+func setup(hosts int, s outlier.Settings) (*serviceregistry.StandardRegistry, *outlier.Detector) {
+	sr := &serviceregistry.StandardRegistry{}
+	for i := 0; i < hosts; i++ {
+		sr.Add("testSvc01", "testKey01", registry.Target{Address: fmt.Sprintf("localhost:%d", 8080+i)})
+	}
+	d := outlier.NewDetector(sr)
+	d.Configure(map[string]map[string]outlier.Settings{"testSvc01": {"testKey01": s}})
+	return sr, d
+}
+
+func record(d *outlier.Detector, host int, requests int, failures int, latency time.Duration) {
+	for i := 0; i < requests; i++ {
+		d.Record("testSvc01", "testKey01", fmt.Sprintf("localhost:%d", 8080+host), i < failures, latency)
+	}
+}
+
+func available(d *outlier.Detector, hosts int) []bool {
+	result := make([]bool, hosts)
+	for i := range result {
+		result[i] = d.Available("testSvc01", "testKey01", fmt.Sprintf("localhost:%d", 8080+i))
+	}
+	return result
+}
+
+func TestDetector_SuccessRate(t *testing.T) {
+	_, d := setup(5, outlier.Settings{})
+	for i := 0; i < 5; i++ {
+		record(d, i, 100, 0, time.Millisecond)
+	}
+	record(d, 2, 100, 50, time.Millisecond)
+	d.Analyse("testSvc01", "testKey01")
+	if got := fmt.Sprint(available(d, 5)); got != "[true true false true true]" {
+		t.Error("Expected failing target to be ejected got ", got)
+	}
+
+	//Too few requests to analyse.
+	_, d = setup(5, outlier.Settings{})
+	for i := 0; i < 5; i++ {
+		record(d, i, 50, 0, time.Millisecond)
+	}
+	record(d, 2, 49, 49, time.Millisecond)
+	d.Analyse("testSvc01", "testKey01")
+	if got := fmt.Sprint(available(d, 5)); got != "[true true true true true]" {
+		t.Error("Expected no ejection below MinRequests got ", got)
+	}
+}
+
+func TestDetector_MaxEjectionPercent(t *testing.T) {
+	_, d := setup(10, outlier.Settings{SuccessRateStdevFactor: 1})
+	for i := 0; i < 10; i++ {
+		record(d, i, 100, 0, time.Millisecond)
+	}
+	record(d, 3, 100, 90, time.Millisecond)
+	record(d, 7, 100, 90, time.Millisecond)
+	d.Analyse("testSvc01", "testKey01")
+	ejected := 0
+	for _, ok := range available(d, 10) {
+		if !ok {
+			ejected++
+		}
+	}
+	if ejected != 1 {
+		t.Error("Expected a single ejection at 10 percent got ", ejected)
+	}
+}
+
+func TestDetector_Latency(t *testing.T) {
+	_, d := setup(4, outlier.Settings{LatencyFactor: 3, MaxEjectionPercent: 50})
+	for i := 0; i < 4; i++ {
+		record(d, i, 100, 0, 10*time.Millisecond)
+	}
+	record(d, 1, 100, 0, 100*time.Millisecond)
+	d.Analyse("testSvc01", "testKey01")
+	if got := fmt.Sprint(available(d, 4)); got != "[true false true true]" {
+		t.Error("Expected slow target to be ejected got ", got)
+	}
+}
+
+func TestDetector_ConsecutiveFailures(t *testing.T) {
+	sr, d := setup(3, outlier.Settings{ConsecutiveFailures: 5, BaseEjectionSeconds: 1, MaxEjectionPercent: 50})
+	sr.IncrementFailures("testSvc01", "testKey01", registry.Target{Address: "localhost:8081"}, 5)
+	d.Analyse("testSvc01", "testKey01")
+	if got := fmt.Sprint(available(d, 3)); got != "[true true true]" {
+		t.Error("Expected no ejection without requests got ", got)
+	}
+	record(d, 1, 1, 1, time.Millisecond)
+	d.Analyse("testSvc01", "testKey01")
+	if got := fmt.Sprint(available(d, 3)); got != "[true false true]" {
+		t.Error("Expected target with consecutive failures to be ejected got ", got)
+	}
+	time.Sleep(1100 * time.Millisecond)
+	if got := fmt.Sprint(available(d, 3)); got != "[true true true]" {
+		t.Error("Expected target to return after ejection got ", got)
+	}
+	d.Analyse("testSvc01", "testKey01")
+	if got := fmt.Sprint(available(d, 3)); got != "[true true true]" {
+		t.Error("Expected no ejection without requests since returning got ", got)
+	}
+}
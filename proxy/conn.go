@@ -0,0 +1,19 @@
+package proxy
+
+import (
+	"net"
+	"sync"
+)
+
+//Connection to a target that runs release exactly once when closed. Used to maintain the
+//open connection count consulted by connection aware balancers.
+type trackedConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *trackedConn) Close() error {
+	c.once.Do(c.release)
+	return c.Conn.Close()
+}
@@ -0,0 +1,240 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cbergoon/glb/balancer"
+	"github.com/cbergoon/glb/tracing"
+)
+
+const (
+	MIRROR_MAX_BODY    = 1 << 20          //Largest request body in bytes that is mirrored; larger requests are not mirrored.
+	MIRROR_TIMEOUT     = 30 * time.Second //Time a mirrored request may take before it is cancelled.
+	MIRROR_CONCURRENCY = 100              //Mirrored requests in flight at once; further requests are not mirrored.
+)
+
+var ErrInvalidMirror = errors.New("proxy: invalid mirror")
+
+//Service/version a share of the requests of another service/version is copied to. The
+//responses to the copies are discarded.
+type Mirror struct {
+	Service string  //Service mirrored requests are sent to.
+	Version string  //Version mirrored requests are sent to.
+	Percent float64 //Share of requests mirrored, from 0 to 100.
+}
+
+//Sends copies of requests to mirrors over a transport recording shadow traffic.
+type mirrorer struct {
+	transport *balancedTransport //Transport of the proxy recording responses as shadow traffic.
+	slots     chan struct{}      //Semaphore of the mirrored requests in flight.
+}
+
+//Response writer that discards the response to a mirrored request.
+type discardWriter struct {
+	header http.Header
+}
+
+//Request body that copies what the primary request reads. Once the body has been read in full
+//done is called with the copy; if the body is closed first, fails or exceeds MIRROR_MAX_BODY,
+//done is called with complete false. done is called once.
+type teeBody struct {
+	io.ReadCloser
+	lock     sync.Mutex   //Exclusive lock for copied and finished.
+	copied   bytes.Buffer //Body read so far.
+	finished bool         //done has been called.
+	done     func(body []byte, complete bool)
+}
+
+//Reports whether the mirror can be applied. Returns ErrInvalidMirror if the service/version is
+//empty or contains "/" or the percent is out of range.
+func (m Mirror) Validate() error {
+	if err := validateMirror(m); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidMirror, err)
+	}
+	return nil
+}
+
+func validateMirror(m Mirror) error {
+	if m.Service == "" || m.Version == "" || strings.Contains(m.Service, "/") || strings.Contains(m.Version, "/") {
+		return fmt.Errorf("%w: %q/%q", ErrInvalidDestination, m.Service, m.Version)
+	}
+	if m.Percent < 0 || m.Percent > 100 {
+		return fmt.Errorf("percent %v is not from 0 to 100", m.Percent)
+	}
+	return nil
+}
+
+//Reports whether the mirrors, keyed by service then version, can be applied. Returns
+//ErrInvalidMirror detailing the first invalid mirror.
+func ValidateMirrors(mirrors map[string]map[string]Mirror) error {
+	for service, versions := range mirrors {
+		for version, m := range versions {
+			if service == "" || version == "" || strings.Contains(service, "/") || strings.Contains(version, "/") {
+				return fmt.Errorf("%w %s/%s: %v", ErrInvalidMirror, service, version, ErrInvalidDestination)
+			}
+			if err := validateMirror(m); err != nil {
+				return fmt.Errorf("%w %s/%s: %v", ErrInvalidMirror, service, version, err)
+			}
+		}
+	}
+	return nil
+}
+
+func compileMirrors(mirrors map[string]map[string]Mirror) (map[Destination]Mirror, error) {
+	if err := ValidateMirrors(mirrors); err != nil {
+		return nil, err
+	}
+	compiled := make(map[Destination]Mirror)
+	for service, versions := range mirrors {
+		for version, m := range versions {
+			compiled[Destination{service, version}] = m
+		}
+	}
+	return compiled, nil
+}
+
+//Returns the mirror of requests sent to service/version. A nil router has no mirrors.
+func (r *Router) Mirror(service, version string) (Mirror, bool) {
+	if r == nil {
+		return Mirror{}, false
+	}
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	m, ok := r.mirrors[Destination{service, version}]
+	return m, ok
+}
+
+//Creates a mirrorer sending copies over separate pools of t while recording them as shadow
+//traffic. The copies neither report to the circuit breakers or outlier detector nor count
+//against the in-flight requests and connections the balancers of the primary requests use, so
+//a failing mirror cannot change where primary requests are sent.
+func newMirrorer(t *balancedTransport) *mirrorer {
+	shadow := *t
+	shadow.shadow = true
+	shadow.breakers, shadow.detector = nil, nil
+	shadow.requests = balancer.NewCounter()
+	shadow.pools = newPools(balancer.NewCounter(), t.pools.idleConTimeout, t.pools.disableKeepAlive)
+	return &mirrorer{transport: &shadow, slots: make(chan struct{}, MIRROR_CONCURRENCY)}
+}
+
+//Sends a copy of the request to the mirror in the share of requests given by its percent. The
+//copy is forwarded in the background with its own timeout so it neither delays nor outlives the
+//client's response by more than MIRROR_TIMEOUT, and its response is discarded. A request body is
+//copied while the primary request streams it and the copy is sent once the body has been read
+//in full; requests with a body larger than MIRROR_MAX_BODY, whose body is not read in full, or
+//sent while MIRROR_CONCURRENCY copies are in flight, are not mirrored. When span is set the copy
+//is traced as its child "mirror". Returns a function to call once the primary request is
+//complete; it drops a copy still waiting for its body.
+func (mr *mirrorer) send(req *http.Request, m Mirror, span *tracing.Span) func() {
+	if rand.Float64()*100 >= m.Percent {
+		return func() {}
+	}
+	select {
+	case mr.slots <- struct{}{}:
+	default:
+		return func() {}
+	}
+	out := req.Clone(context.Background())
+	if req.Body == nil || req.Body == http.NoBody {
+		mr.forward(out, nil, m, span)
+		return func() {}
+	}
+	if req.ContentLength > MIRROR_MAX_BODY {
+		<-mr.slots
+		return func() {}
+	}
+	tee := &teeBody{ReadCloser: req.Body, done: func(body []byte, complete bool) {
+		if !complete {
+			<-mr.slots
+			return
+		}
+		mr.forward(out, body, m, span)
+	}}
+	req.Body = tee
+	return func() { tee.finish(false) }
+}
+
+//Forwards the copy with the body in the background and releases its slot once it completes.
+func (mr *mirrorer) forward(out *http.Request, body []byte, m Mirror, span *tracing.Span) {
+	shadow := childSpan(span, "mirror", tracing.INTERNAL, m.Service, m.Version)
+	ctx, cancel := context.WithTimeout(context.Background(), MIRROR_TIMEOUT)
+	out = out.WithContext(tracing.ContextWithSpan(ctx, shadow))
+	out.Body = http.NoBody
+	if body != nil {
+		out.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	go func() {
+		defer func() {
+			cancel()
+			<-mr.slots
+		}()
+		(&httputil.ReverseProxy{
+			Director: func(req *http.Request) {
+				req.URL.Scheme = "http"
+				req.URL.Host = m.Service + "/" + m.Version
+			},
+			Transport: mr.transport,
+			ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+				shadow.SetError(err)
+			},
+		}).ServeHTTP(&discardWriter{header: make(http.Header)}, out)
+		shadow.End()
+	}()
+}
+
+func (b *teeBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.lock.Lock()
+	if !b.finished {
+		b.copied.Write(p[:n])
+	}
+	exceeded := b.copied.Len() > MIRROR_MAX_BODY
+	b.lock.Unlock()
+	if exceeded || (err != nil && err != io.EOF) {
+		b.finish(false)
+	} else if err == io.EOF {
+		b.finish(true)
+	}
+	return n, err
+}
+
+func (b *teeBody) Close() error {
+	b.finish(false)
+	return b.ReadCloser.Close()
+}
+
+//Calls done unless it has been called.
+func (b *teeBody) finish(complete bool) {
+	b.lock.Lock()
+	if b.finished {
+		b.lock.Unlock()
+		return
+	}
+	b.finished = true
+	b.lock.Unlock()
+	var body []byte
+	if complete {
+		body = b.copied.Bytes()
+	}
+	b.done(body, complete)
+}
+
+func (d *discardWriter) Header() http.Header {
+	return d.header
+}
+
+func (d *discardWriter) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (d *discardWriter) WriteHeader(int) {}
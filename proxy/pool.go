@@ -0,0 +1,179 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cbergoon/glb/balancer"
+	"github.com/cbergoon/glb/registry"
+	"github.com/cbergoon/glb/tracing"
+)
+
+const MAX_IDLE_CONNS_PER_TARGET = 64 //Idle connections kept open to each target.
+
+//Connection pool of each target. Each target has its own http.Transport so connections are
+//reused with the target a request was balanced to. Pools are rebuilt when the keep-alive
+//settings change and dropped when their target leaves the registry.
+type pools struct {
+	lock             sync.RWMutex                           //Exclusive lock for transports; shared while reading them.
+	transports       map[string]map[string]*pooledTransport //Transport by service/version and address.
+	conns            *balancer.Counter                      //Open connections per target.
+	idleConTimeout   *atomic.Int64                          //Seconds an idle connection is kept open; zero means no limit.
+	disableKeepAlive *atomic.Bool                           //Dial a new connection for each request.
+	swept            atomic.Uint64                          //Registry changes when every pool was last pruned.
+}
+
+//Implemented by registries that count the changes that can remove targets, such as
+//registry.AtomicRegistry.
+type changeCounter interface {
+	Changes() uint64
+}
+
+type pooledTransport struct {
+	*http.Transport
+	idleConTimeout   int64 //Setting the transport was built with.
+	disableKeepAlive bool  //Setting the transport was built with.
+}
+
+func newPools(conns *balancer.Counter, idleConTimeout *atomic.Int64, disableKeepAlive *atomic.Bool) *pools {
+	return &pools{
+		transports:       make(map[string]map[string]*pooledTransport),
+		conns:            conns,
+		idleConTimeout:   idleConTimeout,
+		disableKeepAlive: disableKeepAlive,
+	}
+}
+
+//Returns the transport of the target creating it if it does not exist or the keep-alive
+//settings have changed since it was created. Existing transports are found under the shared
+//lock so requests to different targets do not wait on each other.
+func (p *pools) transport(serviceName, serviceKey, address string) *http.Transport {
+	name := serviceName + "/" + serviceKey
+	idleConTimeout, disableKeepAlive := p.idleConTimeout.Load(), p.disableKeepAlive.Load()
+	p.lock.RLock()
+	t, ok := p.transports[name][address]
+	p.lock.RUnlock()
+	if ok && t.idleConTimeout == idleConTimeout && t.disableKeepAlive == disableKeepAlive {
+		return t.Transport
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.transports[name] == nil {
+		p.transports[name] = make(map[string]*pooledTransport)
+	}
+	t, ok = p.transports[name][address]
+	if ok && t.idleConTimeout == idleConTimeout && t.disableKeepAlive == disableKeepAlive {
+		return t.Transport
+	}
+	if ok {
+		t.CloseIdleConnections()
+	}
+	var d net.Dialer
+	t = &pooledTransport{
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				dial := tracing.SpanFromContext(ctx).Child("dial", tracing.CLIENT)
+				dial.SetAttribute("glb.target", address)
+				conn, err := d.DialContext(ctx, network, addr)
+				dial.SetError(err)
+				dial.End()
+				if err != nil {
+					return nil, err
+				}
+				p.conns.Increment(serviceName, serviceKey, address)
+				return &trackedConn{Conn: conn, release: func() {
+					p.conns.Decrement(serviceName, serviceKey, address)
+				}}, nil
+			},
+			TLSHandshakeTimeout: 10 * time.Second,
+			IdleConnTimeout:     time.Duration(idleConTimeout) * time.Second,
+			DisableKeepAlives:   disableKeepAlive,
+			MaxIdleConnsPerHost: MAX_IDLE_CONNS_PER_TARGET,
+		},
+		idleConTimeout:   idleConTimeout,
+		disableKeepAlive: disableKeepAlive,
+	}
+	p.transports[name][address] = t
+	return t.Transport
+}
+
+//Closes the idle connections and drops the pools of targets of service/version that are
+//no longer in targets. The pools are checked under the shared lock and the exclusive lock is
+//only taken when the registry has dropped a target.
+func (p *pools) prune(serviceName, serviceKey string, targets registry.OrderedTargets) {
+	name := serviceName + "/" + serviceKey
+	p.lock.RLock()
+	stale := p.stale(name, targets)
+	p.lock.RUnlock()
+	if !stale {
+		return
+	}
+	current := addresses(targets)
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for address, t := range p.transports[name] {
+		if !contains(current, address) {
+			t.CloseIdleConnections()
+			delete(p.transports[name], address)
+		}
+	}
+	if len(p.transports[name]) == 0 {
+		delete(p.transports, name)
+	}
+}
+
+//Prunes the pools of every service/version, not only those still being requested, when the
+//registry has been swapped or had a target deleted since the last sweep. Pools of a
+//service/version no longer in the registry are all dropped.
+func (p *pools) sweep(reg registry.Registry) {
+	c, ok := reg.(changeCounter)
+	if !ok {
+		return
+	}
+	changes, swept := c.Changes(), p.swept.Load()
+	if changes == swept || !p.swept.CompareAndSwap(swept, changes) {
+		return
+	}
+	p.lock.RLock()
+	names := make([]string, 0, len(p.transports))
+	for name := range p.transports {
+		names = append(names, name)
+	}
+	p.lock.RUnlock()
+	for _, name := range names {
+		serviceName, serviceKey, _ := strings.Cut(name, "/")
+		targets, err := reg.Lookup(serviceName, serviceKey)
+		if err != nil && err != registry.ErrServiceNotFound {
+			continue
+		}
+		p.prune(serviceName, serviceKey, targets)
+	}
+}
+
+//Reports whether a pool of service/version belongs to a target no longer in targets.
+func (p *pools) stale(name string, targets registry.OrderedTargets) bool {
+	if len(p.transports[name]) == 0 {
+		return false
+	}
+	current := addresses(targets)
+	for address := range p.transports[name] {
+		if !contains(current, address) {
+			return true
+		}
+	}
+	return false
+}
+
+func addresses(targets registry.OrderedTargets) []string {
+	list := make([]string, len(targets))
+	for i := range targets {
+		list[i] = targets[i].Address
+	}
+	return list
+}
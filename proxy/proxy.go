@@ -1,15 +1,24 @@
 package proxy
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"github.com/cbergoon/glb/accesslog"
+	"github.com/cbergoon/glb/balancer"
+	"github.com/cbergoon/glb/breaker"
+	"github.com/cbergoon/glb/health"
+	"github.com/cbergoon/glb/metrics"
+	"github.com/cbergoon/glb/outlier"
 	"github.com/cbergoon/glb/registry"
+	"github.com/cbergoon/glb/tracing"
 	"log"
 	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -19,7 +28,6 @@ var (
 )
 
 var ParseTarget = parseTarget
-var DialTarget = dialTarget
 
 //Extracts the service name and version from the URL provided. Returns ErrInvalidPath if
 //the service and/or version are missing from the URL provided.
@@ -38,84 +46,87 @@ func parseTarget(target *url.URL) (name, version string, err error) {
 	return name, version, nil
 }
 
-//Establishes the network connection to appropriate address that is determined by the service and version.
-//Executes a look up with the registry based on the parameters service and version. If a connection is not
-//able to be established to any of the available addresses, an error is returned detailing the failure.
-//Note that this function may or may not be called at deterministic intervals depending on the configuration,
-//request volume and, load balancer settings.
-func dialTarget(network, serviceName, serviceKey string, reg registry.Registry) (net.Conn, error) {
-	localRoundRobbin, err := reg.GetRoundRobbinCounter(serviceName, serviceKey)
-	if localRoundRobbin < 0 || err != nil {
-		log.Print(err)
-		return nil, err
+//Creates a new reverse proxy that represents the configuration specified. A target is selected
+//for every request by the balancer configured for the service/version and the request is
+//forwarded over the connection pool of that target, so requests are distributed evenly
+//regardless of keep-alives. IdleConnTimeout and DisableKeepAlives tune each target's pool. A
+//http.Handler function is returned which will complete the proxy loop when invoked. The
+//checker may be nil in which case every target is considered healthy, balancers may be nil
+//in which case every service/version is balanced by round robin, breakers and detector may be
+//nil in which case no target is ejected, metrics may be nil in which case no traffic is
+//counted, accessLog may be nil in which case requests are not logged and, tracer may be nil in
+//which case no request ID or trace context is added. When the tracer exports spans each sampled
+//request is recorded as a server span with child spans for target selection, dials and the
+//round trip to each target attempted. Requests are resolved to a service/version by the router;
+//a nil router resolves by path with ParseTarget. Basic mode sends every request that does not
+//match a virtual host of the router to default/default. Requests resolved to a service/version
+//with a mirror of the router are copied to the mirror in the share it is configured for; the
+//responses to the copies are discarded and recorded by metrics as shadow traffic.
+func NewLoadBalanceHostReverseProxy(reg registry.Registry, checker *health.Checker, balancers *balancer.Selector, breakers *breaker.Breakers, detector *outlier.Detector, metrics *metrics.Metrics, accessLog *accesslog.Logger, tracer *tracing.Tracer, router *Router, basic *atomic.Bool, idleConTimeout *atomic.Int64, disableKeepAlive *atomic.Bool) http.HandlerFunc {
+	if balancers == nil {
+		balancers = balancer.NewSelector(reg)
 	}
-	endpoints, err := reg.Lookup(serviceName, serviceKey)
-	if err != nil {
-		log.Print(err)
-		return nil, err
-	}
-
-	for {
-		if len(endpoints) == 0 {
-			break
-		}
-
-		if localRoundRobbin >= len(endpoints) {
-			localRoundRobbin = 0
-			reg.SetRoundRobbinCounter(serviceName, serviceKey, 0)
-		}
-
-		endpoint := endpoints[localRoundRobbin].Address
-
-		conn, err := net.Dial(network, endpoint)
-		if err != nil {
-			log.Printf("proxy: error could not access %s/%s at %s", serviceName, serviceKey, endpoint)
-			endpoints = append(endpoints[:localRoundRobbin], endpoints[localRoundRobbin+1:]...)
-			continue
-		}
-
-		localRoundRobbin = localRoundRobbin + 1
-		reg.SetRoundRobbinCounter(serviceName, serviceKey, localRoundRobbin)
-		return conn, nil
-	}
-	e := fmt.Errorf("proxy: error no endpoint available for %s/%s", serviceName, serviceKey)
-	log.Print(e)
-	return nil, e
-}
-
-//Creates a new reverse proxy that represents the configuration specified. This is done by
-//creating a new http.Transport object that utilizes configuration passed in the dial
-//function defined above. A http.Handler function is returned which will complete the proxy
-//loop when invoked.
-func NewLoadBalanceHostReverseProxy(reg registry.Registry, basic *bool, idleConTimeout *int, disableKeepAlive *bool) http.HandlerFunc {
-	transport := &http.Transport{
-		Proxy: http.ProxyFromEnvironment,
-		Dial: func(network, addr string) (net.Conn, error) {
-			addr = strings.Split(addr, ":")[0]
-			tmp := strings.Split(addr, "/")
-			if len(tmp) != 2 {
-				log.Print(ErrInvalidTarget)
-				return nil, ErrInvalidTarget
-			}
-			return DialTarget(network, tmp[0], tmp[1], reg)
-		},
-		TLSHandshakeTimeout: 10 * time.Second,
-		IdleConnTimeout:     time.Duration(*idleConTimeout) * time.Second,
-		DisableKeepAlives:   *disableKeepAlive,
+	transport := &balancedTransport{
+		reg:       reg,
+		checker:   checker,
+		balancers: balancers,
+		breakers:  breakers,
+		detector:  detector,
+		metrics:   metrics,
+		requests:  balancers.Requests,
+		pools:     newPools(balancers.Connections, idleConTimeout, disableKeepAlive),
 	}
+	mirrors := newMirrorer(transport)
 	return func(w http.ResponseWriter, req *http.Request) {
 		var name, key string
 		var err error
-		if !(*basic) {
-			name, key, err = ParseTarget(req.URL)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
+		traced := tracer.Inject(w, req)
+		span := tracer.StartSpan("proxy", tracing.SERVER, traced.Span, traced.Parent)
+		if accessLog != nil || span != nil {
+			rec, entry := &record{}, newEntry(req)
+			recorder := &responseRecorder{ResponseWriter: w}
+			ctx := context.WithValue(req.Context(), recordKey{}, rec)
+			req = req.WithContext(tracing.ContextWithSpan(ctx, span))
+			w = recorder
+			span.SetAttribute("http.request.method", entry.Method)
+			span.SetAttribute("url.path", entry.Path)
+			span.SetAttribute("client.address", entry.ClientIP)
+			if traced.ID != "" {
+				span.SetAttribute("glb.request_id", traced.ID)
 			}
+			defer func() {
+				entry.Service, entry.Version, entry.Target = name, key, rec.target
+				entry.Status, entry.Bytes = recorder.status, recorder.bytes
+				if entry.Status == 0 {
+					entry.Status = http.StatusOK
+				}
+				entry.Duration = time.Since(entry.Time)
+				entry.RequestID = traced.ID
+				if traced.Header != "" {
+					entry.TraceID = traced.Span.TraceIDString()
+				}
+				accessLog.Log(entry)
+				if rec.target != "" {
+					span.SetAttribute("glb.target", rec.target)
+				}
+				span.SetAttribute("http.response.status_code", entry.Status)
+				if entry.Status >= http.StatusInternalServerError {
+					span.SetError(fmt.Errorf("proxy: responded %d", entry.Status))
+				}
+				span.End()
+			}()
 		}
-		if *basic {
-			name = "default"
-			key = "default"
+		name, key, err = router.Resolve(req, basic.Load())
+		if err != nil {
+			span.SetError(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		span.SetAttribute("glb.service", name)
+		span.SetAttribute("glb.version", key)
+		if m, ok := router.Mirror(name, key); ok {
+			//The copy is started now; the deferred call drops it if the body was never read.
+			defer mirrors.send(req, m, span)()
 		}
 		(&httputil.ReverseProxy{
 			Director: func(req *http.Request) {
@@ -123,6 +134,34 @@ func NewLoadBalanceHostReverseProxy(reg registry.Registry, basic *bool, idleConT
 				req.URL.Host = name + "/" + key
 			},
 			Transport: transport,
+			ModifyResponse: func(resp *http.Response) error {
+				//The request ID set on the response by the tracer is kept over one echoed by the target.
+				if traced.Header != "" {
+					resp.Header.Del(traced.Header)
+				}
+				return nil
+			},
 		}).ServeHTTP(w, req)
 	}
 }
+
+//Creates the access log entry of a request before its path is rewritten.
+func newEntry(req *http.Request) accesslog.Entry {
+	clientIP, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		clientIP = req.RemoteAddr
+	}
+	path := req.RequestURI
+	if path == "" {
+		path = req.URL.RequestURI()
+	}
+	return accesslog.Entry{
+		Time:      time.Now(),
+		ClientIP:  clientIP,
+		Method:    req.Method,
+		Path:      path,
+		Proto:     req.Proto,
+		Referer:   req.Referer(),
+		UserAgent: req.UserAgent(),
+	}
+}
@@ -1,18 +1,861 @@
 package proxy_test
 
 import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"github.com/cbergoon/glb/accesslog"
+	"github.com/cbergoon/glb/balancer"
+	"github.com/cbergoon/glb/breaker"
+	"github.com/cbergoon/glb/health"
+	"github.com/cbergoon/glb/metrics"
 	"github.com/cbergoon/glb/proxy"
+	"github.com/cbergoon/glb/registry"
 	"github.com/cbergoon/glb/registry/standardregistry"
+	"github.com/cbergoon/glb/tracing"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 var serviceRegistry = serviceregistry.StandardRegistry{}
 
 func TestNewLoadBalanceHostReverseProxy(t *testing.T) {
-	var FALSE = false
-	var ZERO = 0
-	handlerFunc := proxy.NewLoadBalanceHostReverseProxy(serviceRegistry, &FALSE, &ZERO, &FALSE)
+	var FALSE atomic.Bool
+	var ZERO atomic.Int64
+	handlerFunc := proxy.NewLoadBalanceHostReverseProxy(&serviceRegistry, nil, nil, nil, nil, nil, nil, nil, nil, &FALSE, &ZERO, &FALSE)
 	if handlerFunc == nil {
 		t.Error("Expected handler func got ", handlerFunc)
 	}
 }
+
+//Starts a backend that responds with its own address and returns the address.
+func backend(t *testing.T, status int) string {
+	var addr string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(status)
+		io.WriteString(w, addr)
+	}))
+	t.Cleanup(s.Close)
+	addr = strings.TrimPrefix(s.URL, "http://")
+	return addr
+}
+
+//Starts the proxy in front of the registry with keep-alives enabled and returns its URL.
+func front(t *testing.T, sr registry.Registry, checker *health.Checker, balancers *balancer.Selector, breakers *breaker.Breakers) string {
+	var FALSE atomic.Bool
+	var ZERO atomic.Int64
+	s := httptest.NewServer(proxy.NewLoadBalanceHostReverseProxy(sr, checker, balancers, breakers, nil, nil, nil, nil, nil, &FALSE, &ZERO, &FALSE))
+	t.Cleanup(s.Close)
+	return s.URL
+}
+
+//Requests the URL and returns the response body.
+func get(t *testing.T, url string) string {
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal("Expected nil error got ", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal("Expected nil error got ", err)
+	}
+	return string(body)
+}
+
+//Compiles the routing and applies it to the router; returns the compile error.
+func apply(router *proxy.Router, settings *proxy.RoutingSettings, hosts map[string]proxy.Destination, routes []proxy.Route, splits map[string]map[string]proxy.Split, mirrors map[string]map[string]proxy.Mirror) error {
+	table, err := proxy.CompileRouting(settings, hosts, routes, splits, mirrors)
+	if err != nil {
+		return err
+	}
+	router.Apply(table)
+	return nil
+}
+
+func TestNewLoadBalanceHostReverseProxy_Unhealthy(t *testing.T) {
+	upAddr := backend(t, http.StatusOK)
+	downAddr := backend(t, http.StatusInternalServerError)
+
+	sr := &serviceregistry.StandardRegistry{}
+	sr.Add("testSvc01", "testKey01", registry.Target{Address: downAddr})
+	sr.Add("testSvc01", "testKey01", registry.Target{Address: upAddr})
+	checker := health.NewChecker(sr)
+	checker.Configure(map[string]map[string]health.Settings{"testSvc01": {"testKey01": {}}})
+	checker.Probe("testSvc01", "testKey01")
+	url := front(t, sr, checker, balancer.NewSelector(sr), nil)
+
+	for i := 0; i < 4; i++ {
+		if served := get(t, url+"/testSvc01/testKey01/"); served != upAddr {
+			t.Error("Expected unhealthy target to be skipped got ", served)
+		}
+	}
+	targets, _ := sr.Lookup("testSvc01", "testKey01")
+	if len(targets) != 2 {
+		t.Error("Expected registry to be unchanged got ", targets)
+	}
+}
+
+func TestNewLoadBalanceHostReverseProxy_DialFailure(t *testing.T) {
+	upAddr := backend(t, http.StatusOK)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Could not listen got ", err)
+	}
+	closedAddr := l.Addr().String()
+	l.Close()
+
+	sr := &serviceregistry.StandardRegistry{}
+	sr.Add("testSvc01", "testKey01", registry.Target{Address: closedAddr})
+	sr.Add("testSvc01", "testKey01", registry.Target{Address: upAddr})
+	url := front(t, sr, nil, balancer.NewSelector(sr), nil)
+
+	for i := 0; i < 4; i++ {
+		resp, err := http.Post(url+"/testSvc01/testKey01/", "text/plain", strings.NewReader("body"))
+		if err != nil {
+			t.Fatal("Expected nil error got ", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Error("Expected unreachable target to be skipped got ", resp.Status)
+		}
+	}
+}
+
+func TestNewLoadBalanceHostReverseProxy_Weighted(t *testing.T) {
+	sr := &serviceregistry.StandardRegistry{}
+	addrs := make([]string, 3)
+	for i, weight := range []int{5, 1, 1} {
+		addrs[i] = backend(t, http.StatusOK)
+		sr.Add("testSvc02", "testKey02", registry.Target{Address: addrs[i], Weight: weight})
+	}
+	url := front(t, sr, nil, balancer.NewSelector(sr), nil)
+
+	expected := []int{0, 0, 1, 0, 2, 0, 0, 0, 0, 1, 0, 2, 0, 0}
+	for i, e := range expected {
+		if served := get(t, url+"/testSvc02/testKey02/"); served != addrs[e] {
+			t.Errorf("Expected target %d on request %d got %s", e, i, served)
+		}
+	}
+}
+
+func TestNewLoadBalanceHostReverseProxy_Sweep(t *testing.T) {
+	addr := backend(t, http.StatusOK)
+	first := &serviceregistry.StandardRegistry{}
+	first.Add("testSvc12", "testKey12", registry.Target{Address: addr})
+	first.Add("testSvc13", "testKey13", registry.Target{Address: addr})
+	sr := registry.NewAtomicRegistry(first)
+	balancers := balancer.NewSelector(sr)
+	url := front(t, sr, nil, balancers, nil)
+
+	get(t, url+"/testSvc12/testKey12/")
+	if n := balancers.Connections.Count("testSvc12", "testKey12", addr); n != 1 {
+		t.Fatal("Expected pooled connection got ", n)
+	}
+	second := &serviceregistry.StandardRegistry{}
+	second.Add("testSvc13", "testKey13", registry.Target{Address: addr})
+	sr.Swap(second)
+	get(t, url+"/testSvc13/testKey13/")
+	for i := 0; i < 50 && balancers.Connections.Count("testSvc12", "testKey12", addr) != 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := balancers.Connections.Count("testSvc12", "testKey12", addr); n != 0 {
+		t.Error("Expected pool of service/version no longer in the registry to be closed got ", n)
+	}
+}
+
+func TestNewLoadBalanceHostReverseProxy_InFlight(t *testing.T) {
+	release := make(chan struct{})
+	received := make(chan struct{})
+	b := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		received <- struct{}{}
+		<-release
+	}))
+	defer b.Close()
+	backendAddr := strings.TrimPrefix(b.URL, "http://")
+
+	sr := &serviceregistry.StandardRegistry{}
+	sr.Add("testSvc03", "testKey03", registry.Target{Address: backendAddr})
+	balancers := balancer.NewSelector(sr)
+	url := front(t, sr, nil, balancers, nil)
+
+	done := make(chan error)
+	go func() {
+		resp, err := http.Get(url + "/testSvc03/testKey03/")
+		if err == nil {
+			resp.Body.Close()
+		}
+		done <- err
+	}()
+	<-received
+	if count := balancers.Requests.Count("testSvc03", "testKey03", backendAddr); count != 1 {
+		t.Error("Expected in-flight count of 1 got ", count)
+	}
+	if count := balancers.Connections.Count("testSvc03", "testKey03", backendAddr); count != 1 {
+		t.Error("Expected open connection count of 1 got ", count)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal("Expected nil error got ", err)
+	}
+	for i := 0; balancers.Requests.Count("testSvc03", "testKey03", backendAddr) != 0 && i < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if count := balancers.Requests.Count("testSvc03", "testKey03", backendAddr); count != 0 {
+		t.Error("Expected in-flight count of 0 got ", count)
+	}
+}
+
+func TestNewLoadBalanceHostReverseProxy_Affinity(t *testing.T) {
+	sr := &serviceregistry.StandardRegistry{}
+	for i := 0; i < 3; i++ {
+		sr.Add("testSvc04", "testKey04", registry.Target{Address: backend(t, http.StatusOK)})
+	}
+	balancers := balancer.NewSelector(sr)
+	err := balancers.Configure(map[string]map[string]balancer.Settings{"testSvc04": {"testKey04": {Affinity: &balancer.Affinity{}}}})
+	if err != nil {
+		t.Fatal("Expected nil error got ", err)
+	}
+	url := front(t, sr, nil, balancers, nil)
+
+	request := func(cookie *http.Cookie) (string, *http.Cookie) {
+		req, _ := http.NewRequest("GET", url+"/testSvc04/testKey04/", nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal("Expected nil error got ", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		for _, c := range resp.Cookies() {
+			if c.Name == "glb-testSvc04-testKey04" {
+				return string(body), c
+			}
+		}
+		return string(body), nil
+	}
+	first, cookie := request(nil)
+	if cookie == nil {
+		t.Fatal("Expected affinity cookie")
+	}
+	if strings.Contains(cookie.Value, first) {
+		t.Error("Expected opaque cookie value got ", cookie.Value)
+	}
+	for i := 0; i < 5; i++ {
+		served, c := request(cookie)
+		if served != first {
+			t.Error("Expected sticky target ", first, " got ", served)
+		}
+		if c != nil {
+			t.Error("Expected cookie to be set once got ", c)
+		}
+	}
+	sr.Delete("testSvc04", "testKey04", registry.Target{Address: first})
+	served, c := request(cookie)
+	if served == first {
+		t.Error("Expected removed target to be skipped got ", served)
+	}
+	if c == nil || c.Value == cookie.Value {
+		t.Error("Expected new affinity cookie got ", c)
+	}
+}
+
+func TestNewLoadBalanceHostReverseProxy_CircuitBreaker(t *testing.T) {
+	upAddr := backend(t, http.StatusOK)
+	downAddr := backend(t, http.StatusInternalServerError)
+	sr := &serviceregistry.StandardRegistry{}
+	sr.Add("testSvc05", "testKey05", registry.Target{Address: upAddr})
+	sr.Add("testSvc05", "testKey05", registry.Target{Address: downAddr})
+	breakers := breaker.NewBreakers(sr)
+	breakers.Configure(map[string]map[string]breaker.Settings{"testSvc05": {"testKey05": {Threshold: 2}}})
+	url := front(t, sr, nil, balancer.NewSelector(sr), breakers)
+
+	failures := 0
+	for i := 0; i < 10; i++ {
+		if get(t, url+"/testSvc05/testKey05/") == downAddr {
+			failures++
+		}
+	}
+	if failures != 2 {
+		t.Error("Expected target to be ejected after 2 failures got ", failures)
+	}
+	if state := breakers.State("testSvc05", "testKey05", downAddr); state != breaker.OPEN {
+		t.Error("Expected open breaker got ", state)
+	}
+	targets, _ := sr.Lookup("testSvc05", "testKey05")
+	if targets[1].Failures != 2 {
+		t.Error("Expected failures value of 2 got ", targets[1].Failures)
+	}
+}
+
+func TestNewLoadBalanceHostReverseProxy_CancelledProbe(t *testing.T) {
+	arrived := make(chan struct{}, 1)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		arrived <- struct{}{}
+		<-req.Context().Done()
+	}))
+	defer s.Close()
+	addr := strings.TrimPrefix(s.URL, "http://")
+	sr := &serviceregistry.StandardRegistry{}
+	sr.Add("testSvc11", "testKey11", registry.Target{Address: addr})
+	breakers := breaker.NewBreakers(sr)
+	breakers.Configure(map[string]map[string]breaker.Settings{"testSvc11": {"testKey11": {Threshold: 1, CooldownSeconds: 1}}})
+	breakers.Failure("testSvc11", "testKey11", addr)
+	url := front(t, sr, nil, balancer.NewSelector(sr), breakers)
+
+	time.Sleep(1100 * time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, "GET", url+"/testSvc11/testKey11/", nil)
+	done := make(chan struct{})
+	go func() {
+		if resp, err := http.DefaultClient.Do(req); err == nil {
+			resp.Body.Close()
+		}
+		close(done)
+	}()
+	<-arrived
+	if state := breakers.State("testSvc11", "testKey11", addr); state != breaker.HALF_OPEN {
+		t.Error("Expected half-open breaker during probe got ", state)
+	}
+	cancel()
+	<-done
+	for i := 0; i < 50 && !breakers.Available("testSvc11", "testKey11", addr); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !breakers.Available("testSvc11", "testKey11", addr) {
+		t.Error("Expected target to be available for a new probe after the probe was cancelled")
+	}
+}
+
+func TestNewLoadBalanceHostReverseProxy_AccessLog(t *testing.T) {
+	addr := backend(t, http.StatusOK)
+	sr := &serviceregistry.StandardRegistry{}
+	sr.Add("testSvc06", "testKey06", registry.Target{Address: addr})
+	path := filepath.Join(t.TempDir(), "access.log")
+	logger := accesslog.NewLogger()
+	if err := logger.Configure(&accesslog.Settings{Format: accesslog.JSON, Path: path}); err != nil {
+		t.Fatal("Expected nil error got ", err)
+	}
+	defer logger.Close()
+	tracer := tracing.NewTracer()
+	tracer.Configure(&tracing.Settings{})
+	var FALSE atomic.Bool
+	var ZERO atomic.Int64
+	s := httptest.NewServer(proxy.NewLoadBalanceHostReverseProxy(sr, nil, nil, nil, nil, nil, logger, tracer, nil, &FALSE, &ZERO, &FALSE))
+	defer s.Close()
+
+	resp, err := http.Get(s.URL + "/testSvc06/testKey06/index.html?q=1")
+	if err != nil {
+		t.Fatal("Expected nil error got ", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	get(t, s.URL+"/invalid")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal("Expected nil error got ", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatal("Expected 2 lines got ", lines)
+	}
+	var served, invalid map[string]interface{}
+	json.Unmarshal([]byte(lines[0]), &served)
+	json.Unmarshal([]byte(lines[1]), &invalid)
+	if served["ClientIP"] != "127.0.0.1" || served["Method"] != "GET" || served["Path"] != "/testSvc06/testKey06/index.html?q=1" ||
+		served["Service"] != "testSvc06" || served["Version"] != "testKey06" || served["Target"] != addr ||
+		served["Status"] != 200.0 || served["Bytes"] != float64(len(addr)) || served["RequestID"] != resp.Header.Get("X-Request-Id") {
+		t.Error("Expected proxied request fields got ", served)
+	}
+	if invalid["Path"] != "/invalid" || invalid["Status"] != 500.0 || invalid["Target"] != "" {
+		t.Error("Expected unresolved request fields got ", invalid)
+	}
+}
+
+func TestNewLoadBalanceHostReverseProxy_Tracing(t *testing.T) {
+	received := make(chan http.Header, 1)
+	b := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		received <- req.Header.Clone()
+		w.Header().Set("X-Correlation", "upstream")
+	}))
+	defer b.Close()
+	sr := &serviceregistry.StandardRegistry{}
+	sr.Add("testSvc07", "testKey07", registry.Target{Address: strings.TrimPrefix(b.URL, "http://")})
+	tracer := tracing.NewTracer()
+	tracer.Configure(&tracing.Settings{RequestIDHeader: "X-Correlation"})
+	var FALSE atomic.Bool
+	var ZERO atomic.Int64
+	s := httptest.NewServer(proxy.NewLoadBalanceHostReverseProxy(sr, nil, nil, nil, nil, nil, nil, tracer, nil, &FALSE, &ZERO, &FALSE))
+	defer s.Close()
+
+	const parent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	req, _ := http.NewRequest("GET", s.URL+"/testSvc07/testKey07/", nil)
+	req.Header.Set("traceparent", parent)
+	req.Header.Set("tracestate", "vendor=value")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("Expected nil error got ", err)
+	}
+	resp.Body.Close()
+	upstream := <-received
+	id := resp.Header.Values("X-Correlation")
+	if len(id) != 1 || id[0] == "upstream" || id[0] != upstream.Get("X-Correlation") {
+		t.Error("Expected generated request ID upstream and in response got ", id, " and ", upstream.Get("X-Correlation"))
+	}
+	sc, ok := tracing.ParseTraceparent(upstream.Get("traceparent"))
+	if !ok || sc.TraceIDString() != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanIDString() == "00f067aa0ba902b7" || sc.Flags != 1 {
+		t.Error("Expected child of client traceparent got ", upstream.Get("traceparent"))
+	}
+	if upstream.Get("tracestate") != "vendor=value" {
+		t.Error("Expected tracestate to be forwarded got ", upstream.Get("tracestate"))
+	}
+
+	req, _ = http.NewRequest("GET", s.URL+"/testSvc07/testKey07/", nil)
+	req.Header.Set("X-Correlation", "client-id")
+	req.Header.Set("traceparent", "invalid")
+	req.Header.Set("tracestate", "vendor=value")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("Expected nil error got ", err)
+	}
+	resp.Body.Close()
+	upstream = <-received
+	if resp.Header.Get("X-Correlation") != "client-id" || upstream.Get("X-Correlation") != "client-id" {
+		t.Error("Expected client request ID to be kept got ", resp.Header.Get("X-Correlation"), " and ", upstream.Get("X-Correlation"))
+	}
+	if _, ok := tracing.ParseTraceparent(upstream.Get("traceparent")); !ok || upstream.Get("tracestate") != "" {
+		t.Error("Expected new trace without tracestate got ", upstream.Get("traceparent"), upstream.Get("tracestate"))
+	}
+}
+
+func TestNewLoadBalanceHostReverseProxy_Spans(t *testing.T) {
+	type span struct {
+		TraceID      string
+		SpanID       string
+		ParentSpanID string
+		Name         string
+		Attributes   []struct {
+			Key   string
+			Value map[string]string
+		}
+	}
+	exported := make(chan []span, 10)
+	c := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var body struct {
+			ResourceSpans []struct{ ScopeSpans []struct{ Spans []span } }
+		}
+		json.NewDecoder(req.Body).Decode(&body)
+		exported <- body.ResourceSpans[0].ScopeSpans[0].Spans
+	}))
+	defer c.Close()
+	received := make(chan string, 1)
+	b := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		received <- req.Header.Get("traceparent")
+	}))
+	defer b.Close()
+	addr := strings.TrimPrefix(b.URL, "http://")
+	sr := &serviceregistry.StandardRegistry{}
+	sr.Add("testSvc08", "testKey08", registry.Target{Address: addr})
+	tracer := tracing.NewTracer()
+	tracer.Configure(&tracing.Settings{Endpoint: c.URL, FlushIntervalSeconds: 60})
+	defer tracer.Configure(nil)
+	var FALSE atomic.Bool
+	var ZERO atomic.Int64
+	s := httptest.NewServer(proxy.NewLoadBalanceHostReverseProxy(sr, nil, nil, nil, nil, nil, nil, tracer, nil, &FALSE, &ZERO, &FALSE))
+	defer s.Close()
+
+	get(t, s.URL+"/testSvc08/testKey08/")
+	upstream, _ := tracing.ParseTraceparent(<-received)
+	tracer.Flush()
+	spans := make(map[string]span)
+	for _, sp := range <-exported {
+		spans[sp.Name] = sp
+	}
+	server, selection, dial, roundTrip := spans["proxy"], spans["select target"], spans["dial"], spans["round trip"]
+	if len(spans) != 4 || server.ParentSpanID != "" {
+		t.Fatal("Expected proxy, select target, dial and round trip spans got ", spans)
+	}
+	if selection.ParentSpanID != server.SpanID || roundTrip.ParentSpanID != server.SpanID || dial.ParentSpanID != roundTrip.SpanID {
+		t.Error("Expected span tree got ", spans)
+	}
+	if roundTrip.TraceID != upstream.TraceIDString() || roundTrip.SpanID != upstream.SpanIDString() {
+		t.Error("Expected target to receive round trip span as parent got ", upstream.Traceparent())
+	}
+	attributes := make(map[string]string)
+	for _, a := range roundTrip.Attributes {
+		for _, v := range a.Value {
+			attributes[a.Key] = v
+		}
+	}
+	if attributes["glb.service"] != "testSvc08" || attributes["glb.version"] != "testKey08" || attributes["glb.target"] != addr || attributes["http.response.status_code"] != "200" {
+		t.Error("Expected round trip attributes got ", attributes)
+	}
+}
+
+func TestNewLoadBalanceHostReverseProxy_Mirror(t *testing.T) {
+	primary := backend(t, http.StatusOK)
+	mirrored := make(chan string, 2)
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		io.WriteString(w, "shadow")
+		mirrored <- req.URL.Path + " " + string(body)
+	}))
+	defer shadow.Close()
+	sr := &serviceregistry.StandardRegistry{}
+	sr.Add("testSvc09", "testKey09", registry.Target{Address: primary})
+	sr.Add("testSvc09", "testKey10", registry.Target{Address: strings.TrimPrefix(shadow.URL, "http://")})
+	router := proxy.NewRouter(sr)
+	if err := apply(router, nil, nil, nil, nil, map[string]map[string]proxy.Mirror{"testSvc09": {"testKey09": {Service: "testSvc09", Version: "testKey10", Percent: 101}}}); !errors.Is(err, proxy.ErrInvalidMirror) {
+		t.Error("Expected ErrInvalidMirror got ", err)
+	}
+	err := apply(router, nil, nil, nil, nil, map[string]map[string]proxy.Mirror{"testSvc09": {"testKey09": {Service: "testSvc09", Version: "testKey10", Percent: 100}}})
+	if err != nil {
+		t.Fatal("Expected nil error got ", err)
+	}
+	balancers := balancer.NewSelector(sr)
+	breakers := breaker.NewBreakers(sr)
+	breakers.Configure(map[string]map[string]breaker.Settings{"testSvc09": {"testKey11": {Threshold: 1}}})
+	m := metrics.NewMetrics(sr, balancers)
+	var FALSE atomic.Bool
+	var ZERO atomic.Int64
+	s := httptest.NewServer(proxy.NewLoadBalanceHostReverseProxy(sr, nil, balancers, breakers, nil, m, nil, nil, router, &FALSE, &ZERO, &FALSE))
+	defer s.Close()
+
+	resp, err := http.Post(s.URL+"/testSvc09/testKey09/orders", "text/plain", strings.NewReader("order=1"))
+	if err != nil {
+		t.Fatal("Expected nil error got ", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != primary {
+		t.Error("Expected primary response got ", resp.StatusCode, string(body))
+	}
+	select {
+	case got := <-mirrored:
+		if got != "/orders order=1" {
+			t.Error("Expected mirrored path and body got ", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected request to be mirrored")
+	}
+	var out strings.Builder
+	for i := 0; i < 50; i++ {
+		out.Reset()
+		m.Write(&out)
+		if strings.Contains(out.String(), "glb_shadow_request_duration_seconds_count") {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !strings.Contains(out.String(), `glb_shadow_requests_total{service="testSvc09",version="testKey10",target="`+strings.TrimPrefix(shadow.URL, "http://")+`",code="503"} 1`) ||
+		strings.Contains(out.String(), `glb_requests_total{service="testSvc09",version="testKey10"`) ||
+		!strings.Contains(out.String(), `glb_requests_total{service="testSvc09",version="testKey09",target="`+primary+`",code="200"} 1`) {
+		t.Error("Expected shadow traffic recorded separately got\n", out.String())
+	}
+
+	apply(router, nil, nil, nil, nil, map[string]map[string]proxy.Mirror{"testSvc09": {"testKey09": {Service: "testSvc09", Version: "testKey10", Percent: 0}}})
+	get(t, s.URL+"/testSvc09/testKey09/orders")
+	select {
+	case got := <-mirrored:
+		t.Error("Expected no request to be mirrored at 0 percent got ", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Could not listen got ", err)
+	}
+	closedAddr := l.Addr().String()
+	l.Close()
+	sr.Add("testSvc09", "testKey11", registry.Target{Address: closedAddr})
+	apply(router, nil, nil, nil, nil, map[string]map[string]proxy.Mirror{"testSvc09": {"testKey09": {Service: "testSvc09", Version: "testKey11", Percent: 100}}})
+	get(t, s.URL+"/testSvc09/testKey09/orders")
+	for i := 0; i < 50; i++ {
+		out.Reset()
+		m.Write(&out)
+		if strings.Contains(out.String(), "glb_shadow_no_target_total{") {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !strings.Contains(out.String(), `glb_shadow_dial_errors_total{service="testSvc09",version="testKey11",target="`+closedAddr+`"} 1`) ||
+		!strings.Contains(out.String(), `glb_shadow_no_target_total{service="testSvc09",version="testKey11"} 1`) ||
+		strings.Contains(out.String(), `glb_dial_errors_total{service="testSvc09"`) || strings.Contains(out.String(), `glb_no_target_total{service="testSvc09"`) {
+		t.Error("Expected shadow dial errors recorded separately got\n", out.String())
+	}
+	if _, ok := balancers.Errors.Get("testSvc09", "testKey11", closedAddr); ok {
+		t.Error("Expected shadow dial error to be kept off the status page")
+	}
+	targets, _ := sr.Lookup("testSvc09", "testKey11")
+	if state := breakers.State("testSvc09", "testKey11", closedAddr); state != breaker.CLOSED || targets[0].Failures != 0 {
+		t.Error("Expected shadow dial error to leave the circuit breaker alone got ", state, targets[0].Failures)
+	}
+}
+
+func TestRouter_Resolve(t *testing.T) {
+	sr := &serviceregistry.StandardRegistry{}
+	sr.Add("s1", "v1", registry.Target{Address: "localhost:8080"})
+	router := proxy.NewRouter(sr)
+	resolve := func(path string, header http.Header) (string, string, string, error) {
+		req := httptest.NewRequest("GET", path, nil)
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		name, version, err := router.Resolve(req, false)
+		return name, version, req.URL.Path, err
+	}
+
+	if name, version, path, err := resolve("/unknown/v9/a", nil); err != nil || name != "unknown" || version != "v9" || path != "/a" {
+		t.Error("Expected path routing without settings got ", name, version, path, err)
+	}
+	if err := apply(router, &proxy.RoutingSettings{Order: []string{"host"}}, nil, nil, nil, nil); err != proxy.ErrUnknownSource {
+		t.Error("Expected ErrUnknownSource got ", err)
+	}
+	if err := apply(router, &proxy.RoutingSettings{}, nil, nil, nil, nil); err != nil {
+		t.Fatal("Expected nil error got ", err)
+	}
+	tests := []struct {
+		path    string
+		header  http.Header
+		name    string
+		version string
+		rest    string
+	}{
+		{"/s1/v1/a", http.Header{"X-Service": {"s2"}, "X-Service-Version": {"v2"}}, "s2", "v2", "/s1/v1/a"},
+		{"/s1/v1/a", http.Header{"X-Service": {"s2"}}, "s1", "v1", "/a"},
+		{"/s1/v2/a", nil, "default", "default", "/s1/v2/a"},
+		{"/", nil, "default", "default", "/"},
+	}
+	for _, test := range tests {
+		name, version, path, err := resolve(test.path, test.header)
+		if err != nil || name != test.name || version != test.version || path != test.rest {
+			t.Errorf("Expected %s/%s %s for %s %v got %s/%s %s %v", test.name, test.version, test.rest, test.path, test.header, name, version, path, err)
+		}
+	}
+
+	apply(router, &proxy.RoutingSettings{ServiceHeader: "Svc", VersionHeader: "Ver", Order: []string{"header"}}, nil, nil, nil, nil)
+	if name, version, _, err := resolve("/s1/v1/a", http.Header{"Svc": {"s3"}, "Ver": {"v3"}}); err != nil || name != "s3" || version != "v3" {
+		t.Error("Expected custom headers got ", name, version, err)
+	}
+	if _, _, _, err := resolve("/s1/v1/a", nil); err != proxy.ErrNoRoute {
+		t.Error("Expected ErrNoRoute got ", err)
+	}
+}
+
+func TestRouter_ResolveHost(t *testing.T) {
+	sr := &serviceregistry.StandardRegistry{}
+	router := proxy.NewRouter(sr)
+	if err := apply(router, nil, map[string]proxy.Destination{"orders.*": {Service: "orders", Version: "v1"}}, nil, nil, nil); !errors.Is(err, proxy.ErrInvalidHost) {
+		t.Error("Expected ErrInvalidHost got ", err)
+	}
+	err := apply(router, nil, map[string]proxy.Destination{
+		"Orders.Internal":       {Service: "orders", Version: "v1"},
+		"*.internal":            {Service: "catchall", Version: "v1"},
+		"*.billing.internal":    {Service: "billing", Version: "v2"},
+		"shop.example.com":      {Service: "shop", Version: "v1"},
+		"secure.example.com":    {Service: "secure", Version: "v1"},
+		"*.static.example.com.": {Service: "static", Version: "v1"},
+	}, nil, nil, nil)
+	if err != nil {
+		t.Fatal("Expected nil error got ", err)
+	}
+	tests := []struct {
+		host    string
+		sni     string
+		basic   bool
+		name    string
+		version string
+	}{
+		{"orders.internal:8443", "", false, "orders", "v1"},
+		{"ORDERS.INTERNAL.", "", true, "orders", "v1"},
+		{"eu.billing.internal", "", false, "billing", "v2"},
+		{"billing.internal", "", false, "catchall", "v1"},
+		{"a.b.static.example.com", "", false, "static", "v1"},
+		{"shop.example.com", "secure.example.com", false, "secure", "v1"},
+		{"shop.example.com", "unknown.example.com", false, "shop", "v1"},
+		{"unknown.example.com", "", true, "default", "default"},
+		{"unknown.example.com", "", false, "s1", "v1"},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/s1/v1/a", nil)
+		req.Host = test.host
+		if test.sni != "" {
+			req.TLS = &tls.ConnectionState{ServerName: test.sni}
+		}
+		name, version, err := router.Resolve(req, test.basic)
+		if err != nil || name != test.name || version != test.version {
+			t.Errorf("Expected %s/%s for %s %s got %s/%s %v", test.name, test.version, test.host, test.sni, name, version, err)
+		}
+		if test.name != "s1" && req.URL.Path != "/s1/v1/a" {
+			t.Error("Expected virtual host to keep path got ", req.URL.Path)
+		}
+	}
+}
+
+func TestRouter_ResolveRoutes(t *testing.T) {
+	sr := &serviceregistry.StandardRegistry{}
+	router := proxy.NewRouter(sr)
+	if err := apply(router, nil, nil, []proxy.Route{{PathPrefix: "/api"}}, nil, nil); !errors.Is(err, proxy.ErrInvalidRoute) {
+		t.Error("Expected ErrInvalidRoute got ", err)
+	}
+	err := apply(router, nil, nil, []proxy.Route{
+		{Name: "prefix", PathPrefix: "/api/", Rewrite: "/", Service: "api", Version: "v1"},
+		{Name: "regex", PathRegex: "^/users/([0-9]+)$", Rewrite: "/profiles/$1", Service: "users", Version: "v1"},
+		{Name: "method", Methods: []string{"delete"}, Service: "writes", Version: "v1"},
+		{Name: "header", Headers: map[string]string{"x-beta": "1"}, Service: "beta", Version: "v1"},
+		{Name: "query", Query: map[string]string{"preview": "true"}, Service: "preview", Version: "v1"},
+		{Name: "cidr", ClientCIDRs: []string{"10.0.0.0/8", "fd00::/8"}, Service: "internal", Version: "v1"},
+		{Name: "all", PathPrefix: "/static", Headers: map[string]string{"X-Cdn": "on"}, Service: "cdn", Version: "v1"},
+	}, nil, nil)
+	if err != nil {
+		t.Fatal("Expected nil error got ", err)
+	}
+	tests := []struct {
+		method string
+		target string
+		header http.Header
+		remote string
+		route  string
+		path   string
+	}{
+		{"GET", "/api/orders/1", nil, "", "prefix", "/orders/1"},
+		{"GET", "/users/42", nil, "", "regex", "/profiles/42"},
+		{"GET", "/users/abc", nil, "", "", ""},
+		{"DELETE", "/users/abc", nil, "", "method", "/users/abc"},
+		{"GET", "/x", http.Header{"X-Beta": {"1"}}, "", "header", "/x"},
+		{"GET", "/x", http.Header{"X-Beta": {"2"}}, "", "", ""},
+		{"GET", "/x?preview=true", nil, "", "query", "/x"},
+		{"GET", "/x", nil, "10.1.1.1:5000", "cidr", "/x"},
+		{"GET", "/x", nil, "[fd12::1]:5000", "cidr", "/x"},
+		{"GET", "/static/a.css", http.Header{"X-Cdn": {"on"}}, "", "all", "/static/a.css"},
+		{"GET", "/static/a.css", nil, "", "", ""},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, test.target, nil)
+		for name, values := range test.header {
+			req.Header[name] = values
+		}
+		if test.remote != "" {
+			req.RemoteAddr = test.remote
+		}
+		m, err := router.Match(req, true)
+		if test.route == "" {
+			if m.Source != "basic" {
+				t.Error("Expected no route for ", test.method, test.target, " got ", m, err)
+			}
+			continue
+		}
+		if err != nil || m.Source != "route" || m.Route != test.route || req.URL.Path != test.path || m.Path != test.path {
+			t.Error("Expected route ", test.route, " with path ", test.path, " for ", test.method, test.target, " got ", m, err)
+		}
+	}
+}
+
+func TestRouter_Apply(t *testing.T) {
+	sr := &serviceregistry.StandardRegistry{}
+	router := proxy.NewRouter(sr)
+	hosts := map[string]proxy.Destination{"orders.internal": {Service: "orders", Version: "v1"}}
+	badSplits := map[string]map[string]proxy.Split{"s1": {"v1": {Weights: map[string]int{"v1": 0}}}}
+	if _, err := proxy.CompileRouting(nil, hosts, nil, badSplits, nil); !errors.Is(err, proxy.ErrInvalidSplit) {
+		t.Error("Expected ErrInvalidSplit got ", err)
+	}
+	req := httptest.NewRequest("GET", "http://orders.internal/s1/v1/a", nil)
+	if m, err := router.Match(req, false); err != nil || m.Source != "path" {
+		t.Error("Expected failed compile to leave routing unchanged got ", m, err)
+	}
+	table, err := proxy.CompileRouting(&proxy.RoutingSettings{}, hosts, []proxy.Route{{PathPrefix: "/api/", Service: "api", Version: "v1"}}, nil, nil)
+	if err != nil {
+		t.Fatal("Expected nil error got ", err)
+	}
+	router.Apply(table)
+	if m, err := router.Match(httptest.NewRequest("GET", "http://orders.internal/s1/v1/a", nil), false); err != nil || m.Source != "host" || m.Service != "orders" {
+		t.Error("Expected applied virtual host got ", m, err)
+	}
+	if m, err := router.Match(httptest.NewRequest("GET", "/api/x", nil), false); err != nil || m.Source != "route" {
+		t.Error("Expected applied route got ", m, err)
+	}
+	if m, err := router.Match(httptest.NewRequest("GET", "/x", nil), false); err != nil || m.Source != "default" {
+		t.Error("Expected applied routing settings got ", m, err)
+	}
+}
+
+func TestRouter_Split(t *testing.T) {
+	sr := &serviceregistry.StandardRegistry{}
+	for _, version := range []string{"v1", "v2", "v3"} {
+		sr.Add("s1", version, registry.Target{Address: "localhost:8080"})
+	}
+	router := proxy.NewRouter(sr)
+	if err := apply(router, nil, nil, nil, map[string]map[string]proxy.Split{"s1": {"v1": {Weights: map[string]int{"v1": 0}}}}, nil); !errors.Is(err, proxy.ErrInvalidSplit) {
+		t.Error("Expected ErrInvalidSplit got ", err)
+	}
+	err := apply(router, nil, nil, nil, map[string]map[string]proxy.Split{"s1": {"v1": {Weights: map[string]int{"v1": 3, "v2": 1, "v3": 0}}}}, nil)
+	if err != nil {
+		t.Fatal("Expected nil error got ", err)
+	}
+	counts := make(map[string]int)
+	for i := 0; i < 4000; i++ {
+		m, err := router.Match(httptest.NewRequest("GET", "/s1/v1/a", nil), false)
+		if err != nil || m.Service != "s1" || m.Split != "v1" || m.Pinned || m.Path != "/a" {
+			t.Fatal("Expected split resolution of s1/v1 got ", m, err)
+		}
+		counts[m.Version]++
+	}
+	if counts["v1"] < 2700 || counts["v1"] > 3300 || counts["v2"] < 700 || counts["v2"] > 1300 || counts["v3"] != 0 {
+		t.Error("Expected 3:1 split between v1 and v2 got ", counts)
+	}
+
+	req := httptest.NewRequest("GET", "/s1/v1/a", nil)
+	req.Header.Set(proxy.PIN_HEADER, "v3")
+	if m, err := router.Match(req, false); err != nil || m.Version != "v3" || !m.Pinned {
+		t.Error("Expected header to pin v3 got ", m, err)
+	}
+	req = httptest.NewRequest("GET", "/s1/v1/a", nil)
+	req.AddCookie(&http.Cookie{Name: proxy.PIN_COOKIE, Value: "v2"})
+	if m, err := router.Match(req, false); err != nil || m.Version != "v2" || !m.Pinned {
+		t.Error("Expected cookie to pin v2 got ", m, err)
+	}
+	req = httptest.NewRequest("GET", "/s1/v1/a", nil)
+	req.Header.Set(proxy.PIN_HEADER, "v9")
+	if m, err := router.Match(req, false); err != nil || m.Pinned {
+		t.Error("Expected pin of version outside split to be ignored got ", m, err)
+	}
+	if m, err := router.Match(httptest.NewRequest("GET", "/s1/v2/a", nil), false); err != nil || m.Version != "v2" || m.Split != "" {
+		t.Error("Expected s1/v2 to be unsplit got ", m, err)
+	}
+
+	if err := router.SetSplit("s1", "v1", proxy.Split{Weights: map[string]int{"v1": 1, "v9": 1}}); !errors.Is(err, proxy.ErrInvalidSplit) {
+		t.Error("Expected ErrInvalidSplit for version outside the registry got ", err)
+	}
+	if err := router.SetSplit("s1", "v1", proxy.Split{Weights: map[string]int{"v2": 1}, Header: "X-Canary"}); err != nil {
+		t.Fatal("Expected nil error got ", err)
+	}
+	req = httptest.NewRequest("GET", "/s1/v1/a", nil)
+	req.Header.Set(proxy.PIN_HEADER, "v1")
+	if m, err := router.Match(req, false); err != nil || m.Version != "v2" || m.Pinned {
+		t.Error("Expected runtime split to send all traffic to v2 got ", m, err)
+	}
+	if s, err := router.Split("s1", "v1"); err != nil || s.Header != "X-Canary" || s.Cookie != proxy.PIN_COOKIE {
+		t.Error("Expected split with defaults filled in got ", s, err)
+	}
+	if err := router.RemoveSplit("s1", "v1"); err != nil {
+		t.Error("Expected nil error got ", err)
+	}
+	if err := router.RemoveSplit("s1", "v1"); err != proxy.ErrSplitNotFound {
+		t.Error("Expected ErrSplitNotFound got ", err)
+	}
+	if m, err := router.Match(httptest.NewRequest("GET", "/s1/v1/a", nil), false); err != nil || m.Version != "v1" || m.Split != "" {
+		t.Error("Expected removed split to leave s1/v1 unsplit got ", m, err)
+	}
+}
@@ -0,0 +1,50 @@
+package proxy
+
+import (
+	"net/http"
+)
+
+type recordKey struct{}
+
+//Details of a request gathered by the transport for the access log. Attached to the request
+//context by the handler; the transport runs on the handler's goroutine so no lock is needed.
+type record struct {
+	target string //Address of the target the request was last forwarded to.
+}
+
+//Notes the target the request is being forwarded to if the request carries a record.
+func recordTarget(req *http.Request, address string) {
+	if r, ok := req.Context().Value(recordKey{}).(*record); ok {
+		r.target = address
+	}
+}
+
+//Response writer that captures the status and body size sent to the client.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int   //Status written; zero until the header is written.
+	bytes  int64 //Body bytes written.
+}
+
+//Records the first final status; informational responses precede it.
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 && status >= http.StatusOK {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(p)
+	r.bytes += int64(n)
+	return n, err
+}
+
+//Allows http.ResponseController to reach the flusher of the underlying writer when the
+//reverse proxy streams a response.
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
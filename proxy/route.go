@@ -0,0 +1,150 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidRoute = errors.New("proxy: invalid route")
+
+//Rule of the route table. Every matcher that is set must match for the route to apply; a route
+//without matchers matches every request.
+type Route struct {
+	Name        string            //Identifies the route in test results; defaults to its position in the table.
+	PathPrefix  string            //Path must start with this prefix.
+	PathRegex   string            //Path must match this regular expression.
+	Methods     []string          //Method must be one of these.
+	Headers     map[string]string //Each header must be present with this value.
+	Query       map[string]string //Each query parameter must be present with this value.
+	ClientCIDRs []string          //Client address must be within one of these networks.
+	Service     string            //Service requests are sent to.
+	Version     string            //Version requests are sent to.
+	Rewrite     string            //Replacement of the part of the path matched by PathRegex, or else PathPrefix; path is unchanged if blank.
+}
+
+type compiledRoute struct {
+	Route
+	regex   *regexp.Regexp //Compiled PathRegex; nil if unset.
+	methods map[string]bool
+	nets    []*net.IPNet
+}
+
+//Reports whether the route table can be applied. Returns ErrInvalidRoute detailing the first
+//route with a missing destination, bad regular expression or bad network.
+func ValidateRoutes(routes []Route) error {
+	_, err := compileRoutes(routes)
+	return err
+}
+
+func compileRoutes(routes []Route) ([]*compiledRoute, error) {
+	compiled := make([]*compiledRoute, 0, len(routes))
+	for i, r := range routes {
+		if r.Name == "" {
+			r.Name = strconv.Itoa(i)
+		}
+		c := &compiledRoute{Route: r, methods: make(map[string]bool)}
+		if r.Service == "" || r.Version == "" || strings.Contains(r.Service, "/") || strings.Contains(r.Version, "/") {
+			return nil, fmt.Errorf("%w %s: %v", ErrInvalidRoute, r.Name, ErrInvalidDestination)
+		}
+		if r.PathRegex != "" {
+			regex, err := regexp.Compile(r.PathRegex)
+			if err != nil {
+				return nil, fmt.Errorf("%w %s: %v", ErrInvalidRoute, r.Name, err)
+			}
+			c.regex = regex
+		}
+		for _, m := range r.Methods {
+			c.methods[strings.ToUpper(m)] = true
+		}
+		for _, cidr := range r.ClientCIDRs {
+			_, network, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("%w %s: %v", ErrInvalidRoute, r.Name, err)
+			}
+			c.nets = append(c.nets, network)
+		}
+		compiled = append(compiled, c)
+	}
+	return compiled, nil
+}
+
+//Reports whether the request satisfies every matcher of the route.
+func (c *compiledRoute) matches(req *http.Request) bool {
+	path := req.URL.Path
+	if c.PathPrefix != "" && !strings.HasPrefix(path, c.PathPrefix) {
+		return false
+	}
+	if c.regex != nil && !c.regex.MatchString(path) {
+		return false
+	}
+	if len(c.methods) > 0 && !c.methods[req.Method] {
+		return false
+	}
+	for name, value := range c.Headers {
+		if values, ok := req.Header[http.CanonicalHeaderKey(name)]; !ok || !contains(values, value) {
+			return false
+		}
+	}
+	if len(c.Query) > 0 {
+		query := req.URL.Query()
+		for name, value := range c.Query {
+			if values, ok := query[name]; !ok || !contains(values, value) {
+				return false
+			}
+		}
+	}
+	if len(c.nets) > 0 {
+		host, _, err := net.SplitHostPort(req.RemoteAddr)
+		if err != nil {
+			host = req.RemoteAddr
+		}
+		ip := net.ParseIP(host)
+		found := false
+		for _, network := range c.nets {
+			if ip != nil && network.Contains(ip) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+//Returns the path forwarded to the target. With PathRegex the matches are replaced by Rewrite,
+//which may refer to capture groups as $1; otherwise PathPrefix is replaced by Rewrite.
+func (c *compiledRoute) rewrite(path string) string {
+	if c.Rewrite == "" {
+		return path
+	}
+	var rewritten string
+	if c.regex != nil {
+		rewritten = c.regex.ReplaceAllString(path, c.Rewrite)
+	} else {
+		rest := strings.TrimPrefix(path, c.PathPrefix)
+		if strings.HasSuffix(c.Rewrite, "/") && strings.HasPrefix(rest, "/") {
+			rest = rest[1:]
+		}
+		rewritten = c.Rewrite + rest
+	}
+	if !strings.HasPrefix(rewritten, "/") {
+		rewritten = "/" + rewritten
+	}
+	return rewritten
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
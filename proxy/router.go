@@ -0,0 +1,318 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/cbergoon/glb/registry"
+)
+
+const (
+	SOURCE_HEADER  = "header"  //Service and version are read from request headers.
+	SOURCE_PATH    = "path"    //Service and version are the first two path segments.
+	SOURCE_DEFAULT = "default" //The service/version default/default.
+	SOURCE_ROUTE   = "route"   //A rule of the route table; reported by Match only.
+	SOURCE_HOST    = "host"    //A virtual host; reported by Match only.
+	SOURCE_BASIC   = "basic"   //Basic mode; reported by Match only.
+
+	SERVICE_HEADER = "X-Service"         //Default header naming the service.
+	VERSION_HEADER = "X-Service-Version" //Default header naming the version.
+)
+
+var (
+	ErrUnknownSource      = errors.New("proxy: routing source must be header, path or default")
+	ErrNoRoute            = errors.New("proxy: no service/version found in request")
+	ErrInvalidHost        = errors.New("proxy: virtual host must be a hostname or a *.domain wildcard")
+	ErrInvalidDestination = errors.New("proxy: destination service/version must be non-empty and not contain \"/\"")
+)
+
+type RoutingSettings struct {
+	ServiceHeader string   //Header naming the service; defaults to X-Service.
+	VersionHeader string   //Header naming the version; defaults to X-Service-Version.
+	Order         []string //Sources tried in turn; defaults to header, path, default.
+}
+
+//Service/version requests are sent to.
+type Destination struct {
+	Service string
+	Version string
+}
+
+//Outcome of routing a request.
+type Resolution struct {
+	Source  string //What decided the destination; route, host, basic, header, path or default.
+	Route   string //Name of the matching route if Source is route.
+	Service string //Service the request is sent to.
+	Version string //Version the request is sent to.
+	Path    string //Path forwarded to the target.
+	Split   string //Version resolved before a split chose Version; empty if no split applied.
+	Pinned  bool   //Whether the client pinned Version by header or cookie.
+}
+
+//Routing settings, virtual hosts, route table, splits and mirrors compiled by CompileRouting to
+//be applied to a router at once.
+type RoutingTable struct {
+	settings  *RoutingSettings
+	hosts     map[string]Destination
+	wildcards []wildcard
+	routes    []*compiledRoute
+	splits    map[Destination]*compiledSplit
+	mirrors   map[Destination]Mirror
+}
+
+//Destination of a wildcard domain; matches hosts ending in suffix.
+type wildcard struct {
+	suffix      string //Domain including the leading dot.
+	destination Destination
+}
+
+//Resolves the service/version a request is addressed to. Without settings the service and
+//version are taken from the path with ParseTarget.
+type Router struct {
+	lock      sync.RWMutex                   //Exclusive lock for settings, hosts, routes, splits and mirrors.
+	reg       registry.Registry              //Registry consulted by the path source.
+	settings  *RoutingSettings               //Settings in effect; nil for path only routing.
+	hosts     map[string]Destination         //Destination of each exact hostname.
+	wildcards []wildcard                     //Destination of each wildcard domain, longest suffix first.
+	routes    []*compiledRoute               //Route table in order.
+	splits    map[Destination]*compiledSplit //Split of each service/version.
+	mirrors   map[Destination]Mirror         //Mirror of each service/version.
+}
+
+//Creates a router that resolves requests by path until it is configured.
+func NewRouter(reg registry.Registry) *Router {
+	return &Router{reg: reg}
+}
+
+//Reports whether the settings can be applied. Returns ErrUnknownSource if the order names an
+//unknown source.
+func (s RoutingSettings) Validate() error {
+	for _, source := range s.Order {
+		if source != SOURCE_HEADER && source != SOURCE_PATH && source != SOURCE_DEFAULT {
+			return ErrUnknownSource
+		}
+	}
+	return nil
+}
+
+//Reports whether the virtual hosts can be applied. Returns ErrInvalidHost if a host is empty,
+//contains a port or path or uses a wildcard other than a leading "*." and, ErrInvalidDestination if
+//a destination is empty or contains "/".
+func ValidateHosts(hosts map[string]Destination) error {
+	for host, d := range hosts {
+		name := strings.TrimPrefix(host, "*.")
+		if name == "" || strings.ContainsAny(name, "*/:") {
+			return fmt.Errorf("%w: %q", ErrInvalidHost, host)
+		}
+		if d.Service == "" || d.Version == "" || strings.Contains(d.Service, "/") || strings.Contains(d.Version, "/") {
+			return fmt.Errorf("%w: %q", ErrInvalidDestination, host)
+		}
+	}
+	return nil
+}
+
+//Splits the virtual hosts into exact hostnames and wildcard domains, longest domain first.
+//Hostnames are lower cased to be matched case insensitively.
+func compileHosts(hosts map[string]Destination) (map[string]Destination, []wildcard, error) {
+	if err := ValidateHosts(hosts); err != nil {
+		return nil, nil, err
+	}
+	exact := make(map[string]Destination)
+	var wildcards []wildcard
+	for host, d := range hosts {
+		host = strings.TrimSuffix(strings.ToLower(host), ".")
+		if strings.HasPrefix(host, "*.") {
+			wildcards = append(wildcards, wildcard{host[1:], d})
+		} else {
+			exact[host] = d
+		}
+	}
+	sort.Slice(wildcards, func(i, j int) bool {
+		if len(wildcards[i].suffix) != len(wildcards[j].suffix) {
+			return len(wildcards[i].suffix) > len(wildcards[j].suffix)
+		}
+		return wildcards[i].suffix < wildcards[j].suffix
+	})
+	return exact, wildcards, nil
+}
+
+//Returns the route table with the default names filled in.
+func (r *Router) Routes() []Route {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	routes := make([]Route, len(r.routes))
+	for i, route := range r.routes {
+		routes[i] = route.Route
+	}
+	return routes
+}
+
+//Returns a copy of the settings with the defaults filled in; nil if s is nil.
+func compileSettings(s *RoutingSettings) (*RoutingSettings, error) {
+	var settings *RoutingSettings
+	if s != nil {
+		if err := s.Validate(); err != nil {
+			return nil, err
+		}
+		copied := *s
+		if copied.ServiceHeader == "" {
+			copied.ServiceHeader = SERVICE_HEADER
+		}
+		if copied.VersionHeader == "" {
+			copied.VersionHeader = VERSION_HEADER
+		}
+		if len(copied.Order) == 0 {
+			copied.Order = []string{SOURCE_HEADER, SOURCE_PATH, SOURCE_DEFAULT}
+		}
+		settings = &copied
+	}
+	return settings, nil
+}
+
+//Compiles the routing settings, virtual hosts, route table, splits and mirrors to be applied to
+//a router with Apply. A nil settings is path only routing. Requests whose TLS server name or Host
+//header matches a hostname, or ends in a wildcard domain, are sent to its destination unless a
+//route of the route table matches first; routes are tried in order before any other routing and
+//the first match applies. Splits and mirrors are keyed by the service then version whose
+//requests they apply to. Returns the first error.
+func CompileRouting(settings *RoutingSettings, hosts map[string]Destination, routes []Route, splits map[string]map[string]Split, mirrors map[string]map[string]Mirror) (*RoutingTable, error) {
+	var t RoutingTable
+	var err error
+	if t.settings, err = compileSettings(settings); err != nil {
+		return nil, err
+	}
+	if t.hosts, t.wildcards, err = compileHosts(hosts); err != nil {
+		return nil, err
+	}
+	if t.routes, err = compileRoutes(routes); err != nil {
+		return nil, err
+	}
+	if t.splits, err = compileSplits(splits); err != nil {
+		return nil, err
+	}
+	if t.mirrors, err = compileMirrors(mirrors); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+//Replaces all routing of the router with the table at once, so no request is resolved by a mix
+//of the old and new routing. Weighted versions of splits that are not in the registry are
+//logged.
+func (r *Router) Apply(t *RoutingTable) {
+	r.logMissingVersions(t.splits)
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.settings, r.hosts, r.wildcards, r.routes = t.settings, t.hosts, t.wildcards, t.routes
+	r.splits, r.mirrors = t.splits, t.mirrors
+}
+
+//Returns the service and version of the request as resolved by Match.
+func (r *Router) Resolve(req *http.Request, basic bool) (name, version string, err error) {
+	m, err := r.Match(req, basic)
+	return m.Service, m.Version, err
+}
+
+//Resolves the service and version of the request and rewrites its path for forwarding. The
+//first matching route of the table applies, then a matching virtual host; its exact hostname
+//before the longest matching wildcard, trying the TLS server name before the Host header.
+//Otherwise basic mode resolves to default/default and, without settings, the path is parsed
+//by ParseTarget. With settings each source is tried in order. The header source applies when
+//both headers are present. The path source applies when the first two path segments name a
+//service/version in the registry; the segments are then removed from the path. The default
+//source always applies. Returns ErrNoRoute if no source applies. A nil router resolves by
+//basic mode or ParseTarget alone.
+//If the resolved service/version has a split, the version is then chosen by the split.
+func (r *Router) Match(req *http.Request, basic bool) (Resolution, error) {
+	m, err := r.match(req, basic)
+	if err == nil && r != nil {
+		r.split(req, &m)
+	}
+	return m, err
+}
+
+func (r *Router) match(req *http.Request, basic bool) (Resolution, error) {
+	var settings *RoutingSettings
+	if r != nil {
+		r.lock.RLock()
+		settings = r.settings
+		routes := r.routes
+		r.lock.RUnlock()
+		for _, route := range routes {
+			if route.matches(req) {
+				req.URL.Path, req.URL.RawPath = route.rewrite(req.URL.Path), ""
+				return Resolution{Source: SOURCE_ROUTE, Route: route.Name, Service: route.Service, Version: route.Version, Path: req.URL.Path}, nil
+			}
+		}
+		if d, ok := r.matchHost(req); ok {
+			return Resolution{Source: SOURCE_HOST, Service: d.Service, Version: d.Version, Path: req.URL.Path}, nil
+		}
+	}
+	if basic {
+		return Resolution{Source: SOURCE_BASIC, Service: "default", Version: "default", Path: req.URL.Path}, nil
+	}
+	if settings == nil {
+		name, version, err := ParseTarget(req.URL)
+		if err != nil {
+			return Resolution{}, err
+		}
+		return Resolution{Source: SOURCE_PATH, Service: name, Version: version, Path: req.URL.Path}, nil
+	}
+	for _, source := range settings.Order {
+		switch source {
+		case SOURCE_HEADER:
+			name, version := req.Header.Get(settings.ServiceHeader), req.Header.Get(settings.VersionHeader)
+			if name != "" && version != "" {
+				return Resolution{Source: SOURCE_HEADER, Service: name, Version: version, Path: req.URL.Path}, nil
+			}
+		case SOURCE_PATH:
+			u := *req.URL
+			name, version, err := ParseTarget(&u)
+			if err != nil {
+				continue
+			}
+			if _, err := r.reg.Lookup(name, version); err != nil {
+				continue
+			}
+			*req.URL = u
+			return Resolution{Source: SOURCE_PATH, Service: name, Version: version, Path: req.URL.Path}, nil
+		case SOURCE_DEFAULT:
+			return Resolution{Source: SOURCE_DEFAULT, Service: "default", Version: "default", Path: req.URL.Path}, nil
+		}
+	}
+	return Resolution{}, ErrNoRoute
+}
+
+//Returns the destination of the virtual host the request is addressed to.
+func (r *Router) matchHost(req *http.Request) (Destination, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	if len(r.hosts) == 0 && len(r.wildcards) == 0 {
+		return Destination{}, false
+	}
+	var names []string
+	if req.TLS != nil && req.TLS.ServerName != "" {
+		names = append(names, req.TLS.ServerName)
+	}
+	names = append(names, req.Host)
+	for _, name := range names {
+		if host, _, err := net.SplitHostPort(name); err == nil {
+			name = host
+		}
+		name = strings.TrimSuffix(strings.ToLower(name), ".")
+		if d, ok := r.hosts[name]; ok {
+			return d, true
+		}
+		for _, w := range r.wildcards {
+			if strings.HasSuffix(name, w.suffix) {
+				return w.destination, true
+			}
+		}
+	}
+	return Destination{}, false
+}
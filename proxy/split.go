@@ -0,0 +1,225 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const (
+	PIN_HEADER = "X-Pin-Version" //Default header naming a pinned version.
+	PIN_COOKIE = "glb-version"   //Default cookie naming a pinned version.
+)
+
+var (
+	ErrInvalidSplit  = errors.New("proxy: invalid split")
+	ErrSplitNotFound = errors.New("proxy: no split for service/version")
+)
+
+//Weighted versions behind a service/version. Requests resolved to the service/version are sent
+//to one of the versions at random in proportion to its weight, unless the client pins a version
+//of the split by header or cookie.
+type Split struct {
+	Weights map[string]int //Relative share of each version; a version of weight zero only receives pinned requests.
+	Header  string         //Header naming a pinned version; defaults to X-Pin-Version.
+	Cookie  string         //Cookie naming a pinned version; defaults to glb-version.
+}
+
+//Split with its versions in a fixed order for selection.
+type compiledSplit struct {
+	Split
+	versions []string //Versions of positive weight, sorted.
+	total    int      //Sum of the weights.
+}
+
+//Reports whether the split can be applied. Returns ErrInvalidSplit if a version is empty or
+//contains "/", a weight is negative or the weights sum to zero.
+func (s Split) Validate() error {
+	if _, err := compileSplit(s); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSplit, err)
+	}
+	return nil
+}
+
+//Reports whether the splits, keyed by service then version, can be applied. Returns
+//ErrInvalidSplit detailing the first invalid split.
+func ValidateSplits(splits map[string]map[string]Split) error {
+	_, err := compileSplits(splits)
+	return err
+}
+
+func compileSplit(s Split) (*compiledSplit, error) {
+	c := &compiledSplit{Split: s}
+	c.Weights = make(map[string]int, len(s.Weights))
+	for version, weight := range s.Weights {
+		if version == "" || strings.Contains(version, "/") {
+			return nil, fmt.Errorf("version %q is empty or contains \"/\"", version)
+		}
+		if weight < 0 {
+			return nil, fmt.Errorf("version %s has a negative weight", version)
+		}
+		c.Weights[version] = weight
+		if weight > 0 {
+			c.versions = append(c.versions, version)
+			c.total += weight
+		}
+	}
+	if c.total == 0 {
+		return nil, errors.New("weights sum to zero")
+	}
+	sort.Strings(c.versions)
+	if c.Header == "" {
+		c.Header = PIN_HEADER
+	}
+	if c.Cookie == "" {
+		c.Cookie = PIN_COOKIE
+	}
+	return c, nil
+}
+
+func compileSplits(splits map[string]map[string]Split) (map[Destination]*compiledSplit, error) {
+	compiled := make(map[Destination]*compiledSplit)
+	for service, versions := range splits {
+		for version, s := range versions {
+			if service == "" || version == "" || strings.Contains(service, "/") || strings.Contains(version, "/") {
+				return nil, fmt.Errorf("%w %s/%s: %v", ErrInvalidSplit, service, version, ErrInvalidDestination)
+			}
+			c, err := compileSplit(s)
+			if err != nil {
+				return nil, fmt.Errorf("%w %s/%s: %v", ErrInvalidSplit, service, version, err)
+			}
+			compiled[Destination{service, version}] = c
+		}
+	}
+	return compiled, nil
+}
+
+//Returns the version the request is sent to and whether the client pinned it. The header is
+//consulted before the cookie and a pin is ignored unless it names a version of the split.
+func (c *compiledSplit) pick(req *http.Request) (string, bool) {
+	if v := req.Header.Get(c.Header); v != "" {
+		if _, ok := c.Weights[v]; ok {
+			return v, true
+		}
+	}
+	if cookie, err := req.Cookie(c.Cookie); err == nil {
+		if _, ok := c.Weights[cookie.Value]; ok {
+			return cookie.Value, true
+		}
+	}
+	n := rand.Intn(c.total)
+	for _, v := range c.versions {
+		if n < c.Weights[v] {
+			return v, false
+		}
+		n -= c.Weights[v]
+	}
+	return c.versions[len(c.versions)-1], false
+}
+
+//Returns the versions of the splits that are not in the registry, each as the service/version
+//of its split followed by the version.
+func (r *Router) missingVersions(splits map[Destination]*compiledSplit) []string {
+	var missing []string
+	for d, c := range splits {
+		for version := range c.Weights {
+			if _, err := r.reg.Lookup(d.Service, version); err != nil {
+				missing = append(missing, d.Service+"/"+d.Version+" version "+version)
+			}
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+//Logs the weighted versions of the splits that are not in the registry; requests sent to them
+//fail until the versions are registered.
+func (r *Router) logMissingVersions(splits map[Destination]*compiledSplit) {
+	for _, m := range r.missingVersions(splits) {
+		log.Printf("proxy: split %s is not in the registry", m)
+	}
+}
+
+//Adds or replaces the split of service/version. The change lasts until the splits are next
+//configured. Returns ErrInvalidSplit if the split is invalid or a weighted version is not in the
+//registry.
+func (r *Router) SetSplit(service, version string, s Split) error {
+	compiled, err := compileSplits(map[string]map[string]Split{service: {version: s}})
+	if err != nil {
+		return err
+	}
+	if missing := r.missingVersions(compiled); len(missing) > 0 {
+		return fmt.Errorf("%w %s: not in the registry", ErrInvalidSplit, missing[0])
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	splits := make(map[Destination]*compiledSplit, len(r.splits)+1)
+	for d, c := range r.splits {
+		splits[d] = c
+	}
+	for d, c := range compiled {
+		splits[d] = c
+	}
+	r.splits = splits
+	return nil
+}
+
+//Removes the split of service/version. Returns ErrSplitNotFound if there is none.
+func (r *Router) RemoveSplit(service, version string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	d := Destination{service, version}
+	if _, ok := r.splits[d]; !ok {
+		return ErrSplitNotFound
+	}
+	splits := make(map[Destination]*compiledSplit, len(r.splits))
+	for k, c := range r.splits {
+		if k != d {
+			splits[k] = c
+		}
+	}
+	r.splits = splits
+	return nil
+}
+
+//Returns the split of service/version with the defaults filled in. Returns ErrSplitNotFound if
+//there is none.
+func (r *Router) Split(service, version string) (Split, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	c, ok := r.splits[Destination{service, version}]
+	if !ok {
+		return Split{}, ErrSplitNotFound
+	}
+	return c.Split, nil
+}
+
+//Returns the splits keyed by service then version with the defaults filled in.
+func (r *Router) Splits() map[string]map[string]Split {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	splits := make(map[string]map[string]Split)
+	for d, c := range r.splits {
+		if splits[d.Service] == nil {
+			splits[d.Service] = make(map[string]Split)
+		}
+		splits[d.Service][d.Version] = c.Split
+	}
+	return splits
+}
+
+//Applies the split of the resolved service/version, if any, to the resolution.
+func (r *Router) split(req *http.Request, m *Resolution) {
+	r.lock.RLock()
+	c, ok := r.splits[Destination{m.Service, m.Version}]
+	r.lock.RUnlock()
+	if !ok {
+		return
+	}
+	m.Split = m.Version
+	m.Version, m.Pinned = c.pick(req)
+}
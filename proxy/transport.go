@@ -0,0 +1,267 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cbergoon/glb/balancer"
+	"github.com/cbergoon/glb/breaker"
+	"github.com/cbergoon/glb/health"
+	"github.com/cbergoon/glb/metrics"
+	"github.com/cbergoon/glb/outlier"
+	"github.com/cbergoon/glb/registry"
+	"github.com/cbergoon/glb/tracing"
+)
+
+//Round tripper that selects a target for each request and forwards the request over the
+//connection pool of that target. Requests address a service/version as "service/version"
+//in the URL host.
+type balancedTransport struct {
+	reg       registry.Registry
+	checker   *health.Checker
+	balancers *balancer.Selector
+	breakers  *breaker.Breakers
+	detector  *outlier.Detector
+	metrics   *metrics.Metrics
+	requests  *balancer.Counter //In-flight requests per target.
+	pools     *pools
+	shadow    bool //Responses are to mirrored requests and recorded as shadow traffic.
+}
+
+func (t *balancedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	tmp := strings.Split(req.URL.Host, "/")
+	if len(tmp) != 2 {
+		log.Print(ErrInvalidTarget)
+		return nil, ErrInvalidTarget
+	}
+	return t.roundTripTarget(req, tmp[0], tmp[1])
+}
+
+//Forwards the request to a target of the service and version. Executes a look up with the
+//registry, skips targets reported unhealthy by the checker or ejected by their circuit breaker
+//or the outlier detector and, lets the balancer configured for the service/version choose among
+//the rest. When session affinity is configured a client carrying an affinity cookie is routed to
+//the target it encodes while that target is available; otherwise the response sets the cookie
+//for the chosen target. If the chosen target cannot be dialed it is removed from consideration
+//and another is chosen; the request body is not consumed by a failed dial so it is safe to
+//resend. Dial errors, 5xx responses and timeouts are reported to the circuit breaker and outlier
+//detector as failures. The request is counted as in-flight against the target until the
+//response body is closed. If no target could be reached an error is returned detailing the
+//failure.
+func (t *balancedTransport) roundTripTarget(req *http.Request, serviceName, serviceKey string) (*http.Response, error) {
+	span := tracing.SpanFromContext(req.Context())
+	selection := childSpan(span, "select target", tracing.INTERNAL, serviceName, serviceKey)
+	t.pools.sweep(t.reg)
+	targets, err := t.reg.Lookup(serviceName, serviceKey)
+	if err != nil {
+		log.Print(err)
+		selection.SetError(err)
+		selection.End()
+		return nil, err
+	}
+	t.pools.prune(serviceName, serviceKey, targets)
+	endpoints := make(registry.OrderedTargets, 0, len(targets))
+	for _, target := range targets {
+		if t.checker.Healthy(serviceName, serviceKey, target.Address) &&
+			t.breakers.Available(serviceName, serviceKey, target.Address) &&
+			t.detector.Available(serviceName, serviceKey, target.Address) {
+			endpoints = append(endpoints, target)
+		}
+	}
+	b := t.balancers.Balancer(serviceName, serviceKey)
+	affinity := t.balancers.Affinity(serviceName, serviceKey)
+	settings, _ := t.breakers.Settings(serviceName, serviceKey)
+	body := req.Body
+	if body != nil && body != http.NoBody {
+		body = noCloseBody{body}
+	}
+
+	for {
+		if len(endpoints) == 0 {
+			break
+		}
+
+		index, pinned := -1, false
+		if affinity != nil {
+			index = affinity.Pick(serviceName, serviceKey, endpoints, req)
+			pinned = index >= 0
+		}
+		if !pinned {
+			index = b.Select(serviceName, serviceKey, endpoints, req)
+		}
+		endpoint := endpoints[index].Address
+		if !t.breakers.Allow(serviceName, serviceKey, endpoint) {
+			endpoints = append(endpoints[:index], endpoints[index+1:]...)
+			continue
+		}
+		selection.SetAttribute("glb.candidates", len(endpoints))
+		selection.SetAttribute("glb.target", endpoint)
+		selection.End()
+
+		ctx, cancel := context.WithCancel(req.Context())
+		var timedOut int32
+		var timer *time.Timer
+		if settings.TimeoutSeconds > 0 {
+			timer = time.AfterFunc(time.Duration(settings.TimeoutSeconds)*time.Second, func() {
+				atomic.StoreInt32(&timedOut, 1)
+				cancel()
+			})
+		}
+		upstream := childSpan(span, "round trip", tracing.CLIENT, serviceName, serviceKey)
+		upstream.SetAttribute("glb.target", endpoint)
+		outreq := req.WithContext(tracing.ContextWithSpan(ctx, upstream))
+		outURL := *req.URL
+		outURL.Host = endpoint
+		outreq.URL = &outURL
+		outreq.Body = body
+		if upstream != nil {
+			//The target is told the round trip span is its parent.
+			outreq.Header = req.Header.Clone()
+			outreq.Header.Set(tracing.TRACEPARENT_HEADER, upstream.SpanContext().Traceparent())
+		}
+
+		recordTarget(req, endpoint)
+		t.requests.Increment(serviceName, serviceKey, endpoint)
+		start := time.Now()
+		resp, err := t.pools.transport(serviceName, serviceKey, endpoint).RoundTrip(outreq)
+		latency := time.Since(start)
+		//The timeout covers the response headers only; it is stopped before the next attempt.
+		if timer != nil {
+			timer.Stop()
+		}
+		if err != nil {
+			upstream.SetError(err)
+			upstream.End()
+			cancel()
+			t.requests.Decrement(serviceName, serviceKey, endpoint)
+			var opErr *net.OpError
+			if errors.As(err, &opErr) && opErr.Op == "dial" {
+				if req.Context().Err() != nil {
+					//The dial was abandoned by the client; not the target's fault.
+					t.breakers.Release(serviceName, serviceKey, endpoint)
+					return nil, err
+				}
+				log.Printf("proxy: error could not access %s/%s at %s", serviceName, serviceKey, endpoint)
+				t.recordError(serviceName, serviceKey, endpoint, err)
+				if t.shadow {
+					t.metrics.ShadowDialError(serviceName, serviceKey, endpoint)
+				} else {
+					t.metrics.DialError(serviceName, serviceKey, endpoint)
+				}
+				t.breakers.Failure(serviceName, serviceKey, endpoint)
+				t.detector.Record(serviceName, serviceKey, endpoint, true, latency)
+				endpoints = append(endpoints[:index], endpoints[index+1:]...)
+				selection = childSpan(span, "select target", tracing.INTERNAL, serviceName, serviceKey)
+				continue
+			}
+			if atomic.LoadInt32(&timedOut) == 1 {
+				t.breakers.Failure(serviceName, serviceKey, endpoint)
+				t.detector.Record(serviceName, serviceKey, endpoint, true, latency)
+				e := fmt.Errorf("proxy: error timeout waiting for %s/%s at %s", serviceName, serviceKey, endpoint)
+				log.Print(e)
+				t.recordError(serviceName, serviceKey, endpoint, e)
+				t.observe(serviceName, serviceKey, endpoint, 0, latency)
+				return nil, e
+			}
+			if req.Context().Err() == nil {
+				t.breakers.Failure(serviceName, serviceKey, endpoint)
+				t.detector.Record(serviceName, serviceKey, endpoint, true, latency)
+				t.recordError(serviceName, serviceKey, endpoint, err)
+				t.observe(serviceName, serviceKey, endpoint, 0, latency)
+			} else {
+				t.breakers.Release(serviceName, serviceKey, endpoint)
+			}
+			return nil, err
+		}
+		failed := resp.StatusCode >= http.StatusInternalServerError
+		upstream.SetAttribute("http.response.status_code", resp.StatusCode)
+		if failed {
+			upstream.SetError(fmt.Errorf("proxy: target responded %s", resp.Status))
+		}
+		upstream.End()
+		if failed {
+			t.breakers.Failure(serviceName, serviceKey, endpoint)
+			e := fmt.Errorf("proxy: %s/%s at %s responded %s", serviceName, serviceKey, endpoint, resp.Status)
+			t.recordError(serviceName, serviceKey, endpoint, e)
+		} else {
+			t.breakers.Success(serviceName, serviceKey, endpoint)
+		}
+		t.detector.Record(serviceName, serviceKey, endpoint, failed, latency)
+		t.observe(serviceName, serviceKey, endpoint, resp.StatusCode, latency)
+		if affinity != nil && !pinned {
+			resp.Header.Add("Set-Cookie", affinity.SetCookie(serviceName, serviceKey, endpoint).String())
+		}
+		resp.Body = &releaseBody{ReadCloser: resp.Body, release: func() {
+			cancel()
+			t.requests.Decrement(serviceName, serviceKey, endpoint)
+		}}
+		return resp, nil
+	}
+	e := fmt.Errorf("proxy: error no endpoint available for %s/%s", serviceName, serviceKey)
+	log.Print(e)
+	selection.SetError(e)
+	selection.End()
+	if t.shadow {
+		t.metrics.ShadowNoTarget(serviceName, serviceKey)
+	} else {
+		t.metrics.NoTarget(serviceName, serviceKey)
+	}
+	return nil, e
+}
+
+//Records the error as the last error of the target shown on the status page. Errors of
+//mirrored requests are only recorded in the shadow metrics.
+func (t *balancedTransport) recordError(serviceName, serviceKey, endpoint string, err error) {
+	if !t.shadow {
+		t.balancers.Errors.Record(serviceName, serviceKey, endpoint, err)
+	}
+}
+
+//Records the response of the target in the metrics of live or, for mirrored requests, shadow
+//traffic.
+func (t *balancedTransport) observe(serviceName, serviceKey, endpoint string, code int, latency time.Duration) {
+	if t.shadow {
+		t.metrics.ObserveShadow(serviceName, serviceKey, endpoint, code, latency)
+	} else {
+		t.metrics.ObserveRequest(serviceName, serviceKey, endpoint, code, latency)
+	}
+}
+
+//Starts a child of span tagged with the service/version. Returns nil if span is nil.
+func childSpan(span *tracing.Span, name string, kind tracing.SpanKind, serviceName, serviceKey string) *tracing.Span {
+	child := span.Child(name, kind)
+	child.SetAttribute("glb.service", serviceName)
+	child.SetAttribute("glb.version", serviceKey)
+	return child
+}
+
+//Request body that survives the transport closing it after a failed attempt. The server
+//closes the underlying body once the request completes.
+type noCloseBody struct {
+	io.Reader
+}
+
+func (noCloseBody) Close() error {
+	return nil
+}
+
+//Response body that runs release exactly once when closed.
+type releaseBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *releaseBody) Close() error {
+	b.once.Do(b.release)
+	return b.ReadCloser.Close()
+}
@@ -0,0 +1,77 @@
+package registry
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+//Registry that delegates to another registry which can be replaced at any time. Consumers
+//hold the AtomicRegistry so a registry rebuilt on reload is swapped in for all of them at
+//once; each call is served entirely by either the old or the new registry.
+type AtomicRegistry struct {
+	lock    sync.RWMutex  //Exclusive lock for current.
+	current Registry      //Registry calls are delegated to.
+	changes atomic.Uint64 //Number of swaps and deletes.
+}
+
+//Creates an atomic registry delegating to r.
+func NewAtomicRegistry(r Registry) *AtomicRegistry {
+	return &AtomicRegistry{current: r}
+}
+
+//Returns the registry calls are currently delegated to.
+func (a *AtomicRegistry) Load() Registry {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	return a.current
+}
+
+//Replaces the registry calls are delegated to and returns the previous registry.
+func (a *AtomicRegistry) Swap(r Registry) Registry {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	previous := a.current
+	a.current = r
+	a.changes.Add(1)
+	return previous
+}
+
+//Returns the number of times the registry has been swapped or had a target deleted. Consumers
+//keeping state per target compare it with the count they last saw to notice targets may have
+//left the registry.
+func (a *AtomicRegistry) Changes() uint64 {
+	return a.changes.Load()
+}
+
+func (a *AtomicRegistry) Add(svcValue string, keyValue string, t Target) {
+	a.Load().Add(svcValue, keyValue, t)
+}
+
+func (a *AtomicRegistry) Delete(svcValue string, keyValue string, t Target) {
+	a.Load().Delete(svcValue, keyValue, t)
+	a.changes.Add(1)
+}
+
+func (a *AtomicRegistry) Lookup(svcValue string, keyValue string) (OrderedTargets, error) {
+	return a.Load().Lookup(svcValue, keyValue)
+}
+
+func (a *AtomicRegistry) IncrementFailures(svcValue string, keyValue string, t Target, amount int) (int, error) {
+	return a.Load().IncrementFailures(svcValue, keyValue, t, amount)
+}
+
+func (a *AtomicRegistry) SetRoundRobbinCounter(svcValue string, keyValue string, value int) (int, error) {
+	return a.Load().SetRoundRobbinCounter(svcValue, keyValue, value)
+}
+
+func (a *AtomicRegistry) GetRoundRobbinCounter(svcValue string, keyValue string) (int, error) {
+	return a.Load().GetRoundRobbinCounter(svcValue, keyValue)
+}
+
+func (a *AtomicRegistry) ServiceNames() []string {
+	return a.Load().ServiceNames()
+}
+
+func (a *AtomicRegistry) KeyNames(svcValue string) ([]string, error) {
+	return a.Load().KeyNames(svcValue)
+}
@@ -1 +1,258 @@
 package buntregistry
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+
+	"github.com/cbergoon/glb/registry"
+	"github.com/tidwall/buntdb"
+)
+
+const keyPrefix = "registry:" //Prefix of database keys holding service/key entries.
+
+type BuntRegistry struct {
+	db       *buntdb.DB     //Embedded database persisting the registry.
+	lock     sync.Mutex     //Exclusive lock for counters.
+	counters map[string]int //Round robbin counter of each service/key read or set since Open, by database key.
+}
+
+type key struct {
+	Service            string
+	Value              string
+	RoundRobbinCounter int
+	Targets            registry.OrderedTargets
+}
+
+//Opens or creates the BuntDB file at path and returns a registry backed by it. Targets and
+//failure counts written to the registry survive a restart, as do round robbin counters once the
+//registry is closed. A path of ":memory:" opens a database that is not persisted to disk.
+func Open(path string) (*BuntRegistry, error) {
+	db, err := buntdb.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &BuntRegistry{db: db, counters: make(map[string]int)}, nil
+}
+
+//Writes the round robbin counters and closes the underlying database flushing any pending
+//writes to disk.
+func (r *BuntRegistry) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	err := r.db.Update(func(tx *buntdb.Tx) error {
+		for dk, counter := range r.counters {
+			svcValue, keyValue, _ := strings.Cut(strings.TrimPrefix(dk, keyPrefix), "/")
+			k, err := getKey(tx, svcValue, keyValue)
+			if err != nil {
+				return err
+			}
+			k.RoundRobbinCounter = counter
+			if err := setKey(tx, k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if cerr := r.db.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+//Reports whether the registry holds no service/key entries. Used to decide whether the
+//registry should be seeded from the configuration file.
+func (r *BuntRegistry) Empty() bool {
+	empty := true
+	r.db.View(func(tx *buntdb.Tx) error {
+		return tx.AscendKeys(keyPrefix+"*", func(k, v string) bool {
+			empty = false
+			return false
+		})
+	})
+	return empty
+}
+
+//Retrieves a slice of targets for specified service/key. If no address is found
+//ErrServiceNotFound is returned.
+func (r *BuntRegistry) Lookup(svcValue string, keyValue string) (registry.OrderedTargets, error) {
+	var k *key
+	err := r.db.View(func(tx *buntdb.Tx) error {
+		var err error
+		k, err = getKey(tx, svcValue, keyValue)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return k.Targets, nil
+}
+
+//Adds an entry to the registry. If the address for an entry exists it will be duplicated.
+//If the service does not exist a new key and target will be created and added to represent
+//the new service. If necessary registry.Lookup can be used to ensure success.
+func (r *BuntRegistry) Add(svcValue string, keyValue string, t registry.Target) {
+	r.db.Update(func(tx *buntdb.Tx) error {
+		k, err := getKey(tx, svcValue, keyValue)
+		if err == registry.ErrServiceNotFound {
+			k = &key{Service: svcValue, Value: keyValue, RoundRobbinCounter: 0}
+		} else if err != nil {
+			return err
+		}
+		k.Targets = append(k.Targets, t)
+		return setKey(tx, k)
+	})
+}
+
+//Removes an address entry for a given service/key entry. If necessary the
+//registry.Lookup function can be used to ensure success.
+func (r *BuntRegistry) Delete(svcValue string, keyValue string, t registry.Target) {
+	r.db.Update(func(tx *buntdb.Tx) error {
+		k, err := getKey(tx, svcValue, keyValue)
+		if err != nil {
+			return err
+		}
+		targetIndex := indexOf(k.Targets, t)
+		if targetIndex < 0 {
+			return nil
+		}
+		k.Targets = append(k.Targets[:targetIndex], k.Targets[targetIndex+1:]...)
+		return setKey(tx, k)
+	})
+}
+
+//Increment failure counter on target. If target is not found ErrServiceNotFound is returned.
+func (r *BuntRegistry) IncrementFailures(svcValue string, keyValue string, t registry.Target, amount int) (int, error) {
+	var failures int
+	err := r.db.Update(func(tx *buntdb.Tx) error {
+		k, err := getKey(tx, svcValue, keyValue)
+		if err != nil {
+			return err
+		}
+		targetIndex := indexOf(k.Targets, t)
+		if targetIndex < 0 {
+			return registry.ErrServiceNotFound
+		}
+		k.Targets[targetIndex].Failures += amount
+		failures = k.Targets[targetIndex].Failures
+		return setKey(tx, k)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return failures, nil
+}
+
+//Set round robbin counter on key. The counter is kept in memory, as it changes on every
+//request, and written to the database on Close. If key is not found ErrServiceNotFound is
+//returned.
+func (r *BuntRegistry) SetRoundRobbinCounter(svcValue string, keyValue string, value int) (int, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if _, err := r.counter(svcValue, keyValue); err != nil {
+		return 0, err
+	}
+	r.counters[dbKey(svcValue, keyValue)] = value
+	return value, nil
+}
+
+//Get round robbin counter on key. If key is not found ErrServiceNotFound is returned.
+func (r *BuntRegistry) GetRoundRobbinCounter(svcValue string, keyValue string) (int, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.counter(svcValue, keyValue)
+}
+
+//Returns the in memory counter of service/key, reading the persisted counter into memory on
+//first use. Entries are never removed so a counter in memory belongs to an existing key. The
+//caller must hold the lock.
+func (r *BuntRegistry) counter(svcValue string, keyValue string) (int, error) {
+	if counter, ok := r.counters[dbKey(svcValue, keyValue)]; ok {
+		return counter, nil
+	}
+	var counter int
+	err := r.db.View(func(tx *buntdb.Tx) error {
+		k, err := getKey(tx, svcValue, keyValue)
+		if err != nil {
+			return err
+		}
+		counter = k.RoundRobbinCounter
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	r.counters[dbKey(svcValue, keyValue)] = counter
+	return counter, nil
+}
+
+//Returns the sorted names of the services in the registry.
+func (r *BuntRegistry) ServiceNames() []string {
+	var names []string
+	r.db.View(func(tx *buntdb.Tx) error {
+		return tx.AscendKeys(keyPrefix+"*", func(k, v string) bool {
+			svc := strings.SplitN(strings.TrimPrefix(k, keyPrefix), "/", 2)[0]
+			if len(names) == 0 || names[len(names)-1] != svc {
+				names = append(names, svc)
+			}
+			return true
+		})
+	})
+	return names
+}
+
+//Returns the sorted versions of service. If service is not found ErrServiceNotFound is returned.
+func (r *BuntRegistry) KeyNames(svcValue string) ([]string, error) {
+	var keys []string
+	r.db.View(func(tx *buntdb.Tx) error {
+		return tx.AscendKeys(dbKey(svcValue, "*"), func(k, v string) bool {
+			keys = append(keys, strings.TrimPrefix(k, dbKey(svcValue, "")))
+			return true
+		})
+	})
+	if len(keys) == 0 {
+		return nil, registry.ErrServiceNotFound
+	}
+	return keys, nil
+}
+
+//Reads and decodes the entry for service/key within the transaction. Returns
+//ErrServiceNotFound if no entry exists.
+func getKey(tx *buntdb.Tx, svcValue string, keyValue string) (*key, error) {
+	data, err := tx.Get(dbKey(svcValue, keyValue))
+	if err == buntdb.ErrNotFound {
+		return nil, registry.ErrServiceNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	var k key
+	if err := json.Unmarshal([]byte(data), &k); err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+//Encodes and writes the entry within the transaction.
+func setKey(tx *buntdb.Tx, k *key) error {
+	data, err := json.Marshal(k)
+	if err != nil {
+		return err
+	}
+	_, _, err = tx.Set(dbKey(k.Service, k.Value), string(data), nil)
+	return err
+}
+
+//Builds the database key for service/key. The "/" separator is not valid within a
+//service or version as the proxy addresses targets as "service/version".
+func dbKey(svcValue string, keyValue string) string {
+	return keyPrefix + svcValue + "/" + keyValue
+}
+
+func indexOf(targets registry.OrderedTargets, t registry.Target) int {
+	for i := range targets {
+		if targets[i].Address == t.Address {
+			return i
+		}
+	}
+	return -1
+}
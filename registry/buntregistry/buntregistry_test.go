@@ -1 +1,448 @@
 package buntregistry_test
+
+import (
+	"fmt"
+	"github.com/cbergoon/glb/registry"
+	"github.com/cbergoon/glb/registry/buntregistry"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var sr registry.Registry = open(":memory:")
+
+func open(path string) *buntregistry.BuntRegistry {
+	r, err := buntregistry.Open(path)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+
+func init() {
+	sr.Add("testSvc01", "testKey01", registry.Target{Address: "localhost:8080"})
+	sr.Add("testSvc01", "testKey02", registry.Target{Address: "localhost:8081"})
+	sr.Add("testSvc02", "testKey03", registry.Target{Address: "localhost"})
+	sr.Add("testSvc03", "testKey04", registry.Target{Address: "localhost:80"})
+	sr.Add("testSvc03", "testKey04", registry.Target{Address: "localhost:8443"})
+	sr.Add("testSvc04", "testKey05", registry.Target{Address: "localhost:9090"})
+	sr.Add("testSvc04", "testKey05", registry.Target{Address: "localhost:9091"})
+	sr.Add("testSvc04", "testKey05", registry.Target{Address: "localhost:9092"})
+	sr.Add("testSvc04", "testKey05", registry.Target{Address: "localhost:9093"})
+}
+
+func TestBuntRegistry_Lookup(t *testing.T) {
+	ot, err := sr.Lookup("testSvc01", "testKey01")
+	if err != nil {
+		t.Error("Expected not nil error, got ", err)
+	}
+	if ot.Len() != 1 {
+		t.Error("Expected slice length of 1 got ", len(ot))
+	}
+	ot, err = sr.Lookup("testSvc01", "testKey02")
+	if err != nil {
+		t.Error("Expected not nil error, got ", err)
+	}
+	if ot.Len() != 1 {
+		t.Error("Expected slice length of 1 got ", len(ot))
+	}
+	ot, err = sr.Lookup("testSvc02", "testKey03")
+	if err != nil {
+		t.Error("Expected not nil error, got ", err)
+	}
+	if ot.Len() != 1 {
+		t.Error("Expected slice length of 1 got ", len(ot))
+	}
+	ot, err = sr.Lookup("testSvc03", "testKey04")
+	if err !=nil {
+		t.Error("Expected not nil error, got ", err)
+	}
+	if ot.Len() != 2 {
+		t.Error("Expected slice length of 2 got ", len(ot))
+	}
+	ot, err = sr.Lookup("testSvc04", "testKey05")
+	if err !=nil {
+		t.Error("Expected not nil error, got ", err)
+	}
+	if ot.Len() != 4 {
+		t.Error("Expected slice length of 4 got ", len(ot))
+	}
+}
+
+func TestBuntRegistry_Add(t *testing.T) {
+	sr.Add("testSvc01", "testKey01", registry.Target{Address: "localhost:8081"})
+	sr.Add("testSvc01", "testKey02", registry.Target{Address: "localhost:8082"})
+	sr.Add("testSvc02", "testKey03", registry.Target{Address: "localhost"})
+	sr.Add("testSvc03", "testKey04", registry.Target{Address: "localhost:81"})
+	sr.Add("testSvc03", "testKey04", registry.Target{Address: "localhost:8444"})
+	sr.Add("testSvc04", "testKey05", registry.Target{Address: "localhost:9094"})
+	sr.Add("testSvc04", "testKey05", registry.Target{Address: "localhost:9095"})
+	sr.Add("testSvc04", "testKey05", registry.Target{Address: "localhost:9096"})
+	sr.Add("testSvc04", "testKey05", registry.Target{Address: "localhost:9097"})
+
+	ot, err := sr.Lookup("testSvc01", "testKey01")
+	if err != nil {
+		t.Error("Expected not nil error, got ", err)
+	}
+	if ot.Len() != 2 {
+		t.Error("Expected slice length of 2 got ", len(ot))
+	}
+	ot, err = sr.Lookup("testSvc01", "testKey02")
+	if err != nil {
+		t.Error("Expected not nil error, got ", err)
+	}
+	if ot.Len() != 2 {
+		t.Error("Expected slice length of 2 got ", len(ot))
+	}
+	ot, err = sr.Lookup("testSvc02", "testKey03")
+	if err != nil {
+		t.Error("Expected not nil error, got ", err)
+	}
+	if ot.Len() != 2 {
+		t.Error("Expected slice length of 2 got ", len(ot))
+	}
+	ot, err = sr.Lookup("testSvc03", "testKey04")
+	if err !=nil {
+		t.Error("Expected not nil error, got ", err)
+	}
+	if ot.Len() != 4 {
+		t.Error("Expected slice length of 4 got ", len(ot))
+	}
+	ot, err = sr.Lookup("testSvc04", "testKey05")
+	if err !=nil {
+		t.Error("Expected not nil error, got ", err)
+	}
+	if ot.Len() != 8 {
+		t.Error("Expected slice length of 8 got ", len(ot))
+	}
+
+	sr = open(":memory:")
+	sr.Add("testSvc01", "testKey01", registry.Target{Address: "localhost:8080"})
+	sr.Add("testSvc01", "testKey02", registry.Target{Address: "localhost:8081"})
+	sr.Add("testSvc02", "testKey03", registry.Target{Address: "localhost"})
+	sr.Add("testSvc03", "testKey04", registry.Target{Address: "localhost:80"})
+	sr.Add("testSvc03", "testKey04", registry.Target{Address: "localhost:8443"})
+	sr.Add("testSvc04", "testKey05", registry.Target{Address: "localhost:9090"})
+	sr.Add("testSvc04", "testKey05", registry.Target{Address: "localhost:9091"})
+	sr.Add("testSvc04", "testKey05", registry.Target{Address: "localhost:9092"})
+	sr.Add("testSvc04", "testKey05", registry.Target{Address: "localhost:9093"})
+
+}
+
+func TestBuntRegistry_Delete(t *testing.T) {
+	sr.Add("testSvc01", "testKey01", registry.Target{Address: "localhost:8081"})
+	sr.Add("testSvc01", "testKey02", registry.Target{Address: "localhost:8082"})
+	sr.Add("testSvc02", "testKey03", registry.Target{Address: "127.0.0.1"})
+	sr.Add("testSvc03", "testKey04", registry.Target{Address: "localhost:81"})
+	sr.Add("testSvc03", "testKey04", registry.Target{Address: "localhost:8444"})
+	sr.Add("testSvc04", "testKey05", registry.Target{Address: "localhost:9094"})
+	sr.Add("testSvc04", "testKey05", registry.Target{Address: "localhost:9095"})
+	sr.Add("testSvc04", "testKey05", registry.Target{Address: "localhost:9096"})
+	sr.Add("testSvc04", "testKey05", registry.Target{Address: "localhost:9097"})
+
+	sr.Delete("testSvc01", "testKey01", registry.Target{Address: "localhost:8081"})
+	sr.Delete("testSvc01", "testKey02", registry.Target{Address: "localhost:8082"})
+	sr.Delete("testSvc02", "testKey03", registry.Target{Address: "127.0.0.1"})
+	sr.Delete("testSvc03", "testKey04", registry.Target{Address: "localhost:81"})
+	sr.Delete("testSvc03", "testKey04", registry.Target{Address: "localhost:8444"})
+	sr.Delete("testSvc04", "testKey05", registry.Target{Address: "localhost:9094"})
+	sr.Delete("testSvc04", "testKey05", registry.Target{Address: "localhost:9095"})
+	sr.Delete("testSvc04", "testKey05", registry.Target{Address: "localhost:9096"})
+	sr.Delete("testSvc04", "testKey05", registry.Target{Address: "localhost:9097"})
+
+	ot, err := sr.Lookup("testSvc01", "testKey01")
+	if err != nil {
+		t.Error("Expected not nil error, got ", err)
+	}
+	if ot.Len() != 1 {
+		t.Error("Expected slice length of 1 got ", len(ot))
+	}
+	ot, err = sr.Lookup("testSvc01", "testKey02")
+	if err != nil {
+		t.Error("Expected not nil error, got ", err)
+	}
+	if ot.Len() != 1 {
+		t.Error("Expected slice length of 1 got ", len(ot))
+	}
+	ot, err = sr.Lookup("testSvc02", "testKey03")
+	if err != nil {
+		t.Error("Expected not nil error, got ", err)
+	}
+	if ot.Len() != 1 {
+		t.Error("Expected slice length of 1 got ", len(ot))
+	}
+	ot, err = sr.Lookup("testSvc03", "testKey04")
+	if err !=nil {
+		t.Error("Expected not nil error, got ", err)
+	}
+	if ot.Len() != 2 {
+		t.Error("Expected slice length of 2 got ", len(ot))
+	}
+	ot, err = sr.Lookup("testSvc04", "testKey05")
+	if err !=nil {
+		t.Error("Expected not nil error, got ", err)
+	}
+	if ot.Len() != 4 {
+		t.Error("Expected slice length of 4 got ", len(ot))
+	}
+}
+
+func TestBuntRegistry_IncrementFailures(t *testing.T) {
+	counter1, err := sr.IncrementFailures("testSvc01", "testKey01", registry.Target{Address: "localhost:8080"}, 1)
+	if err != nil {
+		t.Error("Expected not nil error, got ", err)
+	}
+	if counter1 != 1 {
+		t.Error("Expected failures value of 1 got ", counter1)
+	}
+	counter2, err := sr.IncrementFailures("testSvc01", "testKey01", registry.Target{Address: "localhost:8080"}, 1)
+	if err != nil {
+		t.Error("Expected not nil error, got ", err)
+	}
+	if counter2 != 2 {
+		t.Error("Expected failures value of 2 got ", counter2)
+	}
+	counter3, err := sr.IncrementFailures("testSvc01", "testKey01", registry.Target{Address: "localhost:8080"}, 1)
+	if err != nil {
+		t.Error("Expected not nil error, got ", err)
+	}
+	if counter3 != 3 {
+		t.Error("Expected failures value of 3 got ", counter3)
+	}
+	counter4, err := sr.IncrementFailures("testSvc02", "testKey03", registry.Target{Address: "localhost"}, 5)
+	if err != nil {
+		t.Error("Expected not nil error, got ", err)
+	}
+	if counter4 != 5 {
+		t.Error("Expected failures value of 5 got ", counter4)
+	}
+	counter5, err := sr.IncrementFailures("testSvc02", "testKey03", registry.Target{Address: "localhost"}, 0)
+	if err != nil {
+		t.Error("Expected not nil error, got ", err)
+	}
+	if counter5 != 5 {
+		t.Error("Expected failures value of 5 got ", counter5)
+	}
+	_, err = sr.IncrementFailures("noExist", "noExist", registry.Target{Address: "localhost:8080"}, 1)
+	if err == nil {
+		t.Error("Expected ErrServiceNotFound got ", err)
+	}
+	_, err = sr.IncrementFailures("testSvc01", "noExist", registry.Target{Address: "localhost:8080"}, 1)
+	if err == nil {
+		t.Error("Expected ErrServiceNotFound got ", err)
+	}
+}
+
+func TestBuntRegistry_SetRoundRobbinCounter(t *testing.T) {
+	counter1, err := sr.SetRoundRobbinCounter("testSvc01", "testKey01", 1)
+	if err != nil {
+		t.Error("Expected not nil error, got ", err)
+	}
+	if counter1 != 1 {
+		t.Error("Expected counter value of 1 got ", counter1)
+	}
+	counter2, err := sr.SetRoundRobbinCounter("testSvc01", "testKey01", 2)
+	if err != nil {
+		t.Error("Expected not nil error, got ", err)
+	}
+	if counter2 != 2 {
+		t.Error("Expected counter value of 2 got ", counter2)
+	}
+	counter3, err := sr.SetRoundRobbinCounter("testSvc01", "testKey01", 3)
+	if err != nil {
+		t.Error("Expected not nil error, got ", err)
+	}
+	if counter3 != 3 {
+		t.Error("Expected counter value of 3 got ", counter3)
+	}
+	counter4, err := sr.SetRoundRobbinCounter("testSvc02", "testKey03", 5)
+	if err != nil {
+		t.Error("Expected not nil error, got ", err)
+	}
+	if counter4 != 5 {
+		t.Error("Expected counter value of 5 got ", counter4)
+	}
+	counter5, err := sr.SetRoundRobbinCounter("testSvc02", "testKey03", 0)
+	if err != nil {
+		t.Error("Expected not nil error, got ", err)
+	}
+	if counter5 != 0 {
+		t.Error("Expected counter value of 5 got ", counter5)
+	}
+	_, err = sr.SetRoundRobbinCounter("noExist", "noExist", 1)
+	if err == nil {
+		t.Error("Expected ErrServiceNotFound got ", err)
+	}
+	_, err = sr.SetRoundRobbinCounter("testSvc01", "noExist", 1)
+	if err == nil {
+		t.Error("Expected ErrServiceNotFound got ", err)
+	}
+}
+
+func TestBuntRegistry_GetRoundRobbinCounter(t *testing.T) {
+	counter1, err := sr.SetRoundRobbinCounter("testSvc01", "testKey01", 1)
+	if err != nil {
+		t.Error("Expected not nil error, got ", err)
+	}
+	if counter1 != 1 {
+		t.Error("Expected counter value of 1 got ", counter1)
+	}
+	getCounter1, err := sr.GetRoundRobbinCounter("testSvc01", "testKey01")
+	if err != nil {
+		t.Error("Expected not nil error, got ", err)
+	}
+	if getCounter1 != 1 {
+		t.Error("Expected counter value of 1 got ", getCounter1)
+	}
+	counter2, err := sr.SetRoundRobbinCounter("testSvc01", "testKey01", 2)
+	if err != nil {
+		t.Error("Expected not nil error, got ", err)
+	}
+	if counter2 != 2 {
+		t.Error("Expected counter value of 2 got ", counter2)
+	}
+	getCounter2, err := sr.GetRoundRobbinCounter("testSvc01", "testKey01")
+	if err != nil {
+		t.Error("Expected not nil error, got ", err)
+	}
+	if getCounter2 != 2 {
+		t.Error("Expected counter value of 2 got ", getCounter2)
+	}
+	counter3, err := sr.SetRoundRobbinCounter("testSvc01", "testKey01", 3)
+	if err != nil {
+		t.Error("Expected not nil error, got ", err)
+	}
+	if counter3 != 3 {
+		t.Error("Expected counter value of 3 got ", counter3)
+	}
+	getCounter3, err := sr.GetRoundRobbinCounter("testSvc01", "testKey01")
+	if err != nil {
+		t.Error("Expected not nil error, got ", err)
+	}
+	if getCounter3 != 3 {
+		t.Error("Expected counter value of 3 got ", getCounter3)
+	}
+	counter4, err := sr.SetRoundRobbinCounter("testSvc02", "testKey03", 5)
+	if err != nil {
+		t.Error("Expected not nil error, got ", err)
+	}
+	if counter4 != 5 {
+		t.Error("Expected counter value of 5 got ", counter4)
+	}
+	getCounter4, err := sr.GetRoundRobbinCounter("testSvc02", "testKey03")
+	if err != nil {
+		t.Error("Expected not nil error, got ", err)
+	}
+	if getCounter4 != 5 {
+		t.Error("Expected counter value of 5 got ", getCounter4)
+	}
+	counter5, err := sr.SetRoundRobbinCounter("testSvc02", "testKey03", 0)
+	if err != nil {
+		t.Error("Expected not nil error, got ", err)
+	}
+	if counter5 != 0 {
+		t.Error("Expected counter value of 5 got ", counter5)
+	}
+	getCounter5, err := sr.GetRoundRobbinCounter("testSvc02", "testKey03")
+	if err != nil {
+		t.Error("Expected not nil error, got ", err)
+	}
+	if getCounter5 != 0 {
+		t.Error("Expected counter value of 5 got ", getCounter5)
+	}
+	_, err = sr.SetRoundRobbinCounter("noExist", "noExist", 1)
+	if err == nil {
+		t.Error("Expected ErrServiceNotFound got ", err)
+	}
+	_, err = sr.SetRoundRobbinCounter("testSvc01", "noExist", 1)
+	if err == nil {
+		t.Error("Expected ErrServiceNotFound got ", err)
+	}
+}
+
+func TestBuntRegistry_Persistence(t *testing.T) {
+	dir, err := os.MkdirTemp("", "buntregistry")
+	if err != nil {
+		t.Fatal("Could not create directory got ", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "glb.db")
+
+	r := open(path)
+	if !r.Empty() {
+		t.Error("Expected empty registry")
+	}
+	r.Add("testSvc01", "testKey01", registry.Target{Address: "localhost:8080"})
+	r.Add("testSvc01", "testKey01", registry.Target{Address: "localhost:8081"})
+	r.IncrementFailures("testSvc01", "testKey01", registry.Target{Address: "localhost:8081"}, 3)
+	before, err := os.Stat(path)
+	if err != nil {
+		t.Fatal("Expected nil error got ", err)
+	}
+	for i := 0; i < 10; i++ {
+		r.SetRoundRobbinCounter("testSvc01", "testKey01", i)
+	}
+	r.SetRoundRobbinCounter("testSvc01", "testKey01", 1)
+	after, err := os.Stat(path)
+	if err != nil {
+		t.Fatal("Expected nil error got ", err)
+	}
+	if after.Size() != before.Size() {
+		t.Error("Expected round robbin counter not to be written before Close got ", after.Size()-before.Size(), " bytes")
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal("Expected nil error got ", err)
+	}
+
+	r = open(path)
+	defer r.Close()
+	if r.Empty() {
+		t.Error("Expected registry to be restored")
+	}
+	ot, err := r.Lookup("testSvc01", "testKey01")
+	if err != nil {
+		t.Error("Expected nil error, got ", err)
+	}
+	if ot.Len() != 2 {
+		t.Fatal("Expected slice length of 2 got ", len(ot))
+	}
+	if ot[1].Address != "localhost:8081" || ot[1].Failures != 3 {
+		t.Error("Expected restored target with failures value of 3 got ", ot[1])
+	}
+	counter, err := r.GetRoundRobbinCounter("testSvc01", "testKey01")
+	if err != nil {
+		t.Error("Expected nil error, got ", err)
+	}
+	if counter != 1 {
+		t.Error("Expected counter value of 1 got ", counter)
+	}
+}
+
+func TestBuntRegistry_Names(t *testing.T) {
+	r := open(":memory:")
+	if names := r.ServiceNames(); len(names) != 0 {
+		t.Error("Expected no services got ", names)
+	}
+	r.Add("testSvc02", "testKey02", registry.Target{Address: "localhost:8080"})
+	r.Add("testSvc01", "testKey02", registry.Target{Address: "localhost:8080"})
+	r.Add("testSvc01", "testKey01", registry.Target{Address: "localhost:8081"})
+	if names := fmt.Sprint(r.ServiceNames()); names != "[testSvc01 testSvc02]" {
+		t.Error("Expected sorted services got ", names)
+	}
+	keys, err := r.KeyNames("testSvc01")
+	if err != nil {
+		t.Error("Expected nil error, got ", err)
+	}
+	if fmt.Sprint(keys) != "[testKey01 testKey02]" {
+		t.Error("Expected sorted keys got ", keys)
+	}
+	_, err = r.KeyNames("noExist")
+	if err != registry.ErrServiceNotFound {
+		t.Error("Expected ErrServiceNotFound got ", err)
+	}
+	r.Add("status", "reload", registry.Target{Address: "localhost:8082"})
+	if ot, err := r.Lookup("status", "reload"); err != nil || len(ot) != 1 {
+		t.Error("Expected former reserved names to be allowed got ", ot, err)
+	}
+}
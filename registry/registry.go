@@ -5,8 +5,8 @@ import (
 )
 
 var (
-	ErrServiceNotFound       = errors.New("registry: target name/key not found")
-	ErrServiceNameNotAllowed = errors.New("registry: service name not allowed; non-allowable service names [reload|status]")
+	ErrServiceNotFound = errors.New("registry: target name/key not found")
+	ErrInvalidTarget   = errors.New("registry: target requires an address without \"/\" and a weight that is not negative")
 )
 
 type Registry interface {
@@ -16,4 +16,6 @@ type Registry interface {
 	IncrementFailures(svcValue string, keyValue string, t Target, amount int) (int, error) //Increments failures counter on target.
 	SetRoundRobbinCounter(svcValue string, keyValue string, value int) (int, error)        //Sets round robbin counter on key.
 	GetRoundRobbinCounter(svcValue string, keyValue string) (int, error)                   //Gets round robbin counter on key.
+	ServiceNames() []string                                                                //Lists the names of services in the registry.
+	KeyNames(svcValue string) ([]string, error)                                            //Lists the versions of a service.
 }
@@ -1 +1,41 @@
-package registry_test
\ No newline at end of file
+package registry_test
+
+import (
+	"github.com/cbergoon/glb/registry"
+	"github.com/cbergoon/glb/registry/standardregistry"
+	"testing"
+)
+
+func TestAtomicRegistry(t *testing.T) {
+	first := &serviceregistry.StandardRegistry{}
+	first.Add("testSvc01", "testKey01", registry.Target{Address: "localhost:8080"})
+	r := registry.NewAtomicRegistry(first)
+	ot, err := r.Lookup("testSvc01", "testKey01")
+	if err != nil || len(ot) != 1 {
+		t.Error("Expected target of first registry got ", ot, err)
+	}
+
+	second := &serviceregistry.StandardRegistry{}
+	second.Add("testSvc02", "testKey01", registry.Target{Address: "localhost:8081"})
+	if previous := r.Swap(second); previous != first {
+		t.Error("Expected first registry to be returned by swap")
+	}
+	if changes := r.Changes(); changes != 1 {
+		t.Error("Expected 1 change after swap got ", changes)
+	}
+	if _, err := r.Lookup("testSvc01", "testKey01"); err != registry.ErrServiceNotFound {
+		t.Error("Expected ErrServiceNotFound after swap got ", err)
+	}
+	r.Add("testSvc02", "testKey01", registry.Target{Address: "localhost:8082"})
+	ot, err = second.Lookup("testSvc02", "testKey01")
+	if err != nil || len(ot) != 2 {
+		t.Error("Expected add to reach second registry got ", ot, err)
+	}
+	if names := r.ServiceNames(); len(names) != 1 || names[0] != "testSvc02" {
+		t.Error("Expected services of second registry got ", names)
+	}
+	r.Delete("testSvc02", "testKey01", registry.Target{Address: "localhost:8082"})
+	if changes := r.Changes(); changes != 2 {
+		t.Error("Expected 2 changes after delete got ", changes)
+	}
+}
@@ -2,6 +2,7 @@ package serviceregistry
 
 import (
 	"github.com/cbergoon/glb/registry"
+	"sort"
 	"sync"
 )
 
@@ -21,17 +22,11 @@ type key struct {
 	Targets            registry.OrderedTargets
 }
 
-//Retrieves a slice of targets for specified service/key. If no address is found
-//ErrServiceNotFound is returned.
+//Retrieves a copy of the targets for specified service/key so callers may iterate it while the
+//registry changes. If no address is found ErrServiceNotFound is returned.
 func (r *StandardRegistry) Lookup(svcValue string, keyValue string) (registry.OrderedTargets, error) {
 	r.lock.RLock()
 	defer r.lock.RUnlock()
-	if svcValue == "reload" || svcValue == "status" {
-		return nil, registry.ErrServiceNameNotAllowed
-	}
-	if keyValue == "reload" || keyValue == "status" {
-		return nil, registry.ErrServiceNameNotAllowed
-	}
 	s, ok := r.Services[svcValue]
 	if !ok {
 		return nil, registry.ErrServiceNotFound
@@ -40,7 +35,7 @@ func (r *StandardRegistry) Lookup(svcValue string, keyValue string) (registry.Or
 	if !ok {
 		return nil, registry.ErrServiceNotFound
 	}
-	return k.Targets, nil
+	return append(registry.OrderedTargets(nil), k.Targets...), nil
 }
 
 //Adds an entry to the registry. If the address for an entry exists it will be duplicated.
@@ -49,12 +44,6 @@ func (r *StandardRegistry) Lookup(svcValue string, keyValue string) (registry.Or
 func (r *StandardRegistry) Add(svcValue string, keyValue string, t registry.Target) {
 	r.lock.Lock()
 	defer r.lock.Unlock()
-	if svcValue == "reload" || svcValue == "status" {
-		return
-	}
-	if keyValue == "reload" || keyValue == "status" {
-		return
-	}
 	if r.Services == nil {
 		r.Services = make(map[string]*service)
 	}
@@ -99,8 +88,12 @@ func (r *StandardRegistry) Delete(svcValue string, keyValue string, t registry.T
 	if targetIndex < 0 {
 		return
 	}
-	r.Services[svcValue].Keys[keyValue].Targets = append(r.Services[svcValue].Keys[keyValue].Targets[:targetIndex], r.Services[svcValue].Keys[keyValue].Targets[targetIndex+1:]...)
+	targets := r.Services[svcValue].Keys[keyValue].Targets
+	remaining := make(registry.OrderedTargets, 0, len(targets)-1)
+	remaining = append(remaining, targets[:targetIndex]...)
+	r.Services[svcValue].Keys[keyValue].Targets = append(remaining, targets[targetIndex+1:]...)
 }
+
 //Increment failure counter on target. If target is not found ErrServiceNotFound is returned.
 func (r *StandardRegistry) IncrementFailures(svcValue string, keyValue string, t registry.Target, amount int) (int, error) {
 	r.lock.Lock()
@@ -152,6 +145,34 @@ func (r *StandardRegistry) GetRoundRobbinCounter(svcValue string, keyValue strin
 	return r.Services[svcValue].Keys[keyValue].RoundRobbinCounter, nil
 }
 
+//Returns the sorted names of the services in the registry.
+func (r *StandardRegistry) ServiceNames() []string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	names := make([]string, 0, len(r.Services))
+	for name := range r.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+//Returns the sorted versions of service. If service is not found ErrServiceNotFound is returned.
+func (r *StandardRegistry) KeyNames(svcValue string) ([]string, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	s, ok := r.Services[svcValue]
+	if !ok {
+		return nil, registry.ErrServiceNotFound
+	}
+	keys := make([]string, 0, len(s.Keys))
+	for key := range s.Keys {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
 func indexOf(length int, predicate func(i int) bool) int {
 	for i := 0; i < length; i++ {
 		if predicate(i) {
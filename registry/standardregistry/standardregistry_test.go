@@ -1,6 +1,7 @@
 package serviceregistry_test
 
 import (
+	"fmt"
 	"github.com/cbergoon/glb/registry"
 	"github.com/cbergoon/glb/registry/standardregistry"
 	"testing"
@@ -59,6 +60,22 @@ func TestStandardRegistry_Lookup(t *testing.T) {
 	}
 }
 
+func TestStandardRegistry_LookupCopy(t *testing.T) {
+	r := &serviceregistry.StandardRegistry{}
+	r.Add("testSvc01", "testKey01", registry.Target{Address: "localhost:8080"})
+	r.Add("testSvc01", "testKey01", registry.Target{Address: "localhost:8081"})
+	ot, _ := r.Lookup("testSvc01", "testKey01")
+	r.Delete("testSvc01", "testKey01", registry.Target{Address: "localhost:8080"})
+	if ot[0].Address != "localhost:8080" || ot[1].Address != "localhost:8081" {
+		t.Error("Expected looked up targets to be unchanged by Delete got ", ot)
+	}
+	ot, _ = r.Lookup("testSvc01", "testKey01")
+	ot[0].Weight = 5
+	if ot, _ = r.Lookup("testSvc01", "testKey01"); len(ot) != 1 || ot[0].Weight != 0 {
+		t.Error("Expected registry to be unchanged by writes to looked up targets got ", ot)
+	}
+}
+
 func TestStandardRegistry_Add(t *testing.T) {
 	sr.Add("testSvc01", "testKey01", registry.Target{Address: "localhost:8081"})
 	sr.Add("testSvc01", "testKey02", registry.Target{Address: "localhost:8082"})
@@ -349,3 +366,31 @@ func TestStandardRegistry_GetRoundRobbinCounter(t *testing.T) {
 		t.Error("Expected ErrServiceNotFound got ", err)
 	}
 }
+
+func TestStandardRegistry_Names(t *testing.T) {
+	r := &serviceregistry.StandardRegistry{}
+	if names := r.ServiceNames(); len(names) != 0 {
+		t.Error("Expected no services got ", names)
+	}
+	r.Add("testSvc02", "testKey02", registry.Target{Address: "localhost:8080"})
+	r.Add("testSvc01", "testKey02", registry.Target{Address: "localhost:8080"})
+	r.Add("testSvc01", "testKey01", registry.Target{Address: "localhost:8081"})
+	if names := fmt.Sprint(r.ServiceNames()); names != "[testSvc01 testSvc02]" {
+		t.Error("Expected sorted services got ", names)
+	}
+	keys, err := r.KeyNames("testSvc01")
+	if err != nil {
+		t.Error("Expected nil error, got ", err)
+	}
+	if fmt.Sprint(keys) != "[testKey01 testKey02]" {
+		t.Error("Expected sorted keys got ", keys)
+	}
+	_, err = r.KeyNames("noExist")
+	if err != registry.ErrServiceNotFound {
+		t.Error("Expected ErrServiceNotFound got ", err)
+	}
+	r.Add("status", "reload", registry.Target{Address: "localhost:8082"})
+	if ot, err := r.Lookup("status", "reload"); err != nil || len(ot) != 1 {
+		t.Error("Expected former reserved names to be allowed got ", ot, err)
+	}
+}
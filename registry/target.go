@@ -5,6 +5,7 @@ import "strings"
 type Target struct {
 	Address  string
 	Failures int
+	Weight   int //Relative share of traffic; zero or less is treated as one.
 }
 
 func (t *Target) setAddress(address string) {
@@ -15,6 +16,23 @@ func (t *Target) getAddress() string {
 	return t.Address
 }
 
+//Reports whether the target can be added to a registry. Returns ErrInvalidTarget if the address
+//is empty or contains "/" or the weight is negative.
+func (t Target) Validate() error {
+	if t.Address == "" || strings.Contains(t.Address, "/") || t.Weight < 0 {
+		return ErrInvalidTarget
+	}
+	return nil
+}
+
+//Returns the weight used for balancing. Targets without a weight have a weight of one.
+func (t *Target) EffectiveWeight() int {
+	if t.Weight <= 0 {
+		return 1
+	}
+	return t.Weight
+}
+
 func (t *Target) incrementFailures(value int) int {
 	t.Failures += value
 	return t.Failures
@@ -22,6 +40,16 @@ func (t *Target) incrementFailures(value int) int {
 
 type OrderedTargets []Target
 
+//Reports whether any target carries a weight other than the default.
+func (s OrderedTargets) Weighted() bool {
+	for i := range s {
+		if s[i].EffectiveWeight() != 1 {
+			return true
+		}
+	}
+	return false
+}
+
 func (s OrderedTargets) Len() int {
 	return len(s)
 }
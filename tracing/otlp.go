@@ -0,0 +1,243 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	SCOPE_NAME     = "github.com/cbergoon/glb" //Instrumentation scope of exported spans.
+	EXPORT_TIMEOUT = 10 * time.Second          //Time allowed for one export request.
+)
+
+//Exports ended spans in batches to an OTLP/HTTP collector using the JSON encoding. Spans are
+//queued and sent every flush interval or once a batch is full; spans are dropped while the
+//queue holds more than four batches.
+type exporter struct {
+	endpoint    string            //URL spans are posted to.
+	headers     map[string]string //Headers added to each export request.
+	serviceName string            //service.name resource attribute.
+	batchSize   int               //Spans sent per export request.
+	client      *http.Client      //Client of the export requests.
+	lock        sync.Mutex        //Exclusive lock for queue and dropped.
+	queue       []*Span           //Ended spans awaiting export.
+	dropped     int               //Spans dropped since the last export.
+	exportLock  sync.Mutex        //Serializes export requests.
+	full        chan struct{}     //Signals that a batch is ready.
+	stop        chan struct{}     //Closed to stop the export loop.
+	done        chan struct{}     //Closed when the export loop exits.
+}
+
+func newExporter(s Settings) *exporter {
+	e := &exporter{
+		endpoint:    s.Endpoint,
+		headers:     s.Headers,
+		serviceName: s.ServiceName,
+		batchSize:   s.BatchSize,
+		client:      &http.Client{Timeout: EXPORT_TIMEOUT},
+		full:        make(chan struct{}, 1),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	go e.run(time.Duration(s.FlushIntervalSeconds) * time.Second)
+	return e
+}
+
+func (e *exporter) run(interval time.Duration) {
+	defer close(e.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-e.full:
+		case <-e.stop:
+			e.flush()
+			return
+		}
+		e.flush()
+	}
+}
+
+//Stops the export loop after exporting the queued spans.
+func (e *exporter) close() {
+	close(e.stop)
+	<-e.done
+}
+
+func (e *exporter) enqueue(s *Span) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if len(e.queue) >= 4*e.batchSize {
+		e.dropped++
+		return
+	}
+	e.queue = append(e.queue, s)
+	if len(e.queue) >= e.batchSize {
+		select {
+		case e.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+//Exports the queued spans in batches. Failed batches are logged and discarded.
+func (e *exporter) flush() {
+	e.exportLock.Lock()
+	defer e.exportLock.Unlock()
+	for {
+		e.lock.Lock()
+		n := len(e.queue)
+		if n > e.batchSize {
+			n = e.batchSize
+		}
+		batch := e.queue[:n:n]
+		e.queue = e.queue[n:]
+		dropped := e.dropped
+		e.dropped = 0
+		e.lock.Unlock()
+		if dropped > 0 {
+			log.Printf("tracing: dropped %d spans; export queue full", dropped)
+		}
+		if n == 0 {
+			return
+		}
+		if err := e.export(batch); err != nil {
+			log.Printf("tracing: error exporting %d spans to %s: %v", n, e.endpoint, err)
+		}
+	}
+}
+
+func (e *exporter) export(batch []*Span) error {
+	data, err := json.Marshal(e.encode(batch))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, e.endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range e.headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector responded %s", resp.Status)
+	}
+	return nil
+}
+
+//OTLP/HTTP JSON request body. IDs are hex encoded and 64 bit integers are strings as the
+//protobuf JSON mapping requires.
+type exportRequest struct {
+	ResourceSpans []resourceSpans `json:"resourceSpans"`
+}
+
+type resourceSpans struct {
+	Resource   resource     `json:"resource"`
+	ScopeSpans []scopeSpans `json:"scopeSpans"`
+}
+
+type resource struct {
+	Attributes []keyValue `json:"attributes"`
+}
+
+type scopeSpans struct {
+	Scope scope      `json:"scope"`
+	Spans []spanJSON `json:"spans"`
+}
+
+type scope struct {
+	Name string `json:"name"`
+}
+
+type spanJSON struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Flags             uint32     `json:"flags"`
+	Name              string     `json:"name"`
+	Kind              SpanKind   `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []keyValue `json:"attributes,omitempty"`
+	Status            status     `json:"status"`
+}
+
+type status struct {
+	Code    int    `json:"code"` //0 unset, 1 ok, 2 error.
+	Message string `json:"message,omitempty"`
+}
+
+type keyValue struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+type anyValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+	BoolValue   *bool   `json:"boolValue,omitempty"`
+}
+
+func (e *exporter) encode(batch []*Span) exportRequest {
+	spans := make([]spanJSON, 0, len(batch))
+	for _, s := range batch {
+		s.lock.Lock()
+		span := spanJSON{
+			TraceID:           s.context.TraceIDString(),
+			SpanID:            s.context.SpanIDString(),
+			Flags:             uint32(s.context.Flags),
+			Name:              s.name,
+			Kind:              s.kind,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+		}
+		if s.parent != ([8]byte{}) {
+			span.ParentSpanID = hex.EncodeToString(s.parent[:])
+		}
+		for _, a := range s.attributes {
+			span.Attributes = append(span.Attributes, keyValue{a.Key, value(a.Value)})
+		}
+		if s.err != "" {
+			span.Status = status{Code: 2, Message: s.err}
+		}
+		s.lock.Unlock()
+		spans = append(spans, span)
+	}
+	return exportRequest{ResourceSpans: []resourceSpans{{
+		Resource:   resource{Attributes: []keyValue{{"service.name", value(e.serviceName)}}},
+		ScopeSpans: []scopeSpans{{Scope: scope{Name: SCOPE_NAME}, Spans: spans}},
+	}}}
+}
+
+func value(v interface{}) anyValue {
+	switch v := v.(type) {
+	case string:
+		return anyValue{StringValue: &v}
+	case int:
+		s := strconv.Itoa(v)
+		return anyValue{IntValue: &s}
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		return anyValue{IntValue: &s}
+	case bool:
+		return anyValue{BoolValue: &v}
+	}
+	s := fmt.Sprint(v)
+	return anyValue{StringValue: &s}
+}
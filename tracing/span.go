@@ -0,0 +1,118 @@
+package tracing
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type SpanKind int
+
+//Span kinds as numbered by OTLP.
+const (
+	INTERNAL SpanKind = 1 //Operation within glb.
+	SERVER   SpanKind = 2 //Handling of a request received from a client.
+	CLIENT   SpanKind = 3 //Request or connection made to a target.
+)
+
+type spanKey struct{}
+
+//Timed operation of a trace exported to the collector when ended. A nil span records nothing
+//so callers need not check whether tracing is enabled.
+type Span struct {
+	tracer     *Tracer     //Tracer whose exporter the span is queued on when ended.
+	name       string      //Name of the operation.
+	kind       SpanKind    //Kind of the operation.
+	context    SpanContext //Identity of the span.
+	parent     [8]byte     //Span ID of the parent; zero for a root span.
+	start      time.Time   //Time the span started.
+	lock       sync.Mutex  //Exclusive lock for the fields below.
+	end        time.Time   //Time the span ended; zero until ended.
+	attributes []attribute //Attributes in the order they were set.
+	err        string      //Error message; the span has error status if non-empty.
+}
+
+type attribute struct {
+	Key   string
+	Value interface{} //string, int, int64 or bool.
+}
+
+//Starts a child of the span with a new span ID in the same trace. Returns nil if the span is nil.
+func (s *Span) Child(name string, kind SpanKind) *Span {
+	if s == nil {
+		return nil
+	}
+	sc := s.context
+	sc.SpanID = NewSpanID()
+	return &Span{tracer: s.tracer, name: name, kind: kind, context: sc, parent: s.context.SpanID, start: time.Now()}
+}
+
+//Returns the identity of the span; the zero SpanContext if the span is nil.
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.context
+}
+
+//Sets an attribute replacing any previous value of the key. Values are strings, ints or bools.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for i := range s.attributes {
+		if s.attributes[i].Key == key {
+			s.attributes[i].Value = value
+			return
+		}
+	}
+	s.attributes = append(s.attributes, attribute{key, value})
+}
+
+//Marks the span as failed with the error. A nil error is ignored.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.err = err.Error()
+}
+
+//Ends the span and queues it on the exporter of the tracer at the time it ends, so a span that
+//outlives a reload is exported by the exporter that replaced the one it started with. The span
+//is dropped if the tracer no longer exports spans. Only the first call has an effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	if !s.end.IsZero() {
+		s.lock.Unlock()
+		return
+	}
+	s.end = time.Now()
+	s.lock.Unlock()
+	s.tracer.lock.RLock()
+	e := s.tracer.exporter
+	s.tracer.lock.RUnlock()
+	if e != nil {
+		e.enqueue(s)
+	}
+}
+
+//Returns a copy of ctx carrying the span. Returns ctx if the span is nil.
+func ContextWithSpan(ctx context.Context, s *Span) context.Context {
+	if s == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, spanKey{}, s)
+}
+
+//Returns the span carried by ctx; nil if none.
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
@@ -0,0 +1,253 @@
+package tracing
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	REQUEST_ID_HEADER  = "X-Request-Id" //Default header carrying the request ID.
+	TRACEPARENT_HEADER = "traceparent"  //W3C trace context header.
+	TRACESTATE_HEADER  = "tracestate"   //W3C vendor trace state header; forwarded unchanged.
+)
+
+var ErrInvalidEndpoint = errors.New("tracing: endpoint must be an http or https URL")
+
+type Settings struct {
+	RequestIDHeader      string            //Header the request ID is read from, forwarded in and returned in; defaults to X-Request-Id.
+	Endpoint             string            //OTLP/HTTP traces URL of the collector, e.g. http://localhost:4318/v1/traces; spans are not exported if blank.
+	Headers              map[string]string //Headers added to each export request, e.g. for authentication.
+	ServiceName          string            //service.name resource attribute of exported spans; defaults to glb.
+	BatchSize            int               //Spans sent per export request; defaults to 512.
+	FlushIntervalSeconds int               //Seconds between exports of queued spans; defaults to 5.
+}
+
+//Position of a request in a distributed trace as carried by the W3C traceparent header.
+type SpanContext struct {
+	TraceID [16]byte //Identifier shared by every span of the trace.
+	SpanID  [8]byte  //Identifier of the span.
+	Flags   byte     //Trace flags; bit 0 is the sampled flag.
+}
+
+//Identifiers assigned to a proxied request.
+type Request struct {
+	Header string      //Header carrying the request ID; empty if the tracer is disabled.
+	ID     string      //Request ID received from the client or generated.
+	Span   SpanContext //Span of glb; its SpanID is sent upstream as the parent.
+	Parent [8]byte     //Span ID of the caller; zero if glb started the trace.
+}
+
+type Tracer struct {
+	lock     sync.RWMutex //Exclusive lock for settings and exporter.
+	settings *Settings    //Settings in effect; nil if disabled.
+	exporter *exporter    //Exporter of spans; nil if spans are not exported.
+}
+
+//Creates a tracer that leaves requests untouched until it is configured.
+func NewTracer() *Tracer {
+	return &Tracer{}
+}
+
+//Reports whether the settings can be applied. Returns ErrInvalidEndpoint if the endpoint is set
+//and is not an http or https URL.
+func (s Settings) Validate() error {
+	if s.Endpoint == "" {
+		return nil
+	}
+	u, err := url.Parse(s.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ErrInvalidEndpoint
+	}
+	return nil
+}
+
+//Replaces the settings. A nil settings disables request IDs, trace propagation and export.
+//The exporter is kept if the export settings are unchanged. Otherwise spans queued by the
+//previous exporter are exported in the background before it is stopped, so Configure does not
+//wait on the collector, and spans still in progress are exported by the new exporter.
+func (t *Tracer) Configure(s *Settings) {
+	var settings *Settings
+	if s != nil {
+		copied := *s
+		if copied.RequestIDHeader == "" {
+			copied.RequestIDHeader = REQUEST_ID_HEADER
+		}
+		if copied.ServiceName == "" {
+			copied.ServiceName = "glb"
+		}
+		if copied.BatchSize <= 0 {
+			copied.BatchSize = 512
+		}
+		if copied.FlushIntervalSeconds <= 0 {
+			copied.FlushIntervalSeconds = 5
+		}
+		settings = &copied
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	previous := t.exporter
+	if sameExport(t.settings, settings) {
+		t.settings = settings
+		return
+	}
+	t.settings, t.exporter = settings, nil
+	if settings != nil && settings.Endpoint != "" {
+		t.exporter = newExporter(*settings)
+	}
+	if previous != nil {
+		go previous.close()
+	}
+}
+
+//Reports whether spans are exported the same way under both settings; nil settings export
+//nothing.
+func sameExport(a, b *Settings) bool {
+	if a == nil || a.Endpoint == "" || b == nil || b.Endpoint == "" {
+		return (a == nil || a.Endpoint == "") && (b == nil || b.Endpoint == "")
+	}
+	return a.Endpoint == b.Endpoint && a.ServiceName == b.ServiceName && a.BatchSize == b.BatchSize &&
+		a.FlushIntervalSeconds == b.FlushIntervalSeconds && maps.Equal(a.Headers, b.Headers)
+}
+
+//Starts a span with the identity sc whose parent is the span ID parent. Returns nil if the
+//tracer is nil, spans are not exported or the trace is not sampled.
+func (t *Tracer) StartSpan(name string, kind SpanKind, sc SpanContext, parent [8]byte) *Span {
+	if t == nil || sc.Flags&1 == 0 {
+		return nil
+	}
+	t.lock.RLock()
+	e := t.exporter
+	t.lock.RUnlock()
+	if e == nil {
+		return nil
+	}
+	return &Span{tracer: t, name: name, kind: kind, context: sc, parent: parent, start: time.Now()}
+}
+
+//Exports the queued spans now rather than waiting for the flush interval.
+func (t *Tracer) Flush() {
+	if t == nil {
+		return
+	}
+	t.lock.RLock()
+	e := t.exporter
+	t.lock.RUnlock()
+	if e != nil {
+		e.flush()
+	}
+}
+
+//Assigns the request an ID and a span. The request ID header of the client is kept or, when
+//missing, generated and set on the request; it is also set on the response so it is returned
+//to the client. A valid traceparent from the client is continued with a new child span ID;
+//otherwise a new trace is started and any tracestate is dropped. The traceparent forwarded
+//upstream names the span of glb as the parent. Returns the zero Request and leaves the request
+//untouched if the tracer is nil or disabled.
+func (t *Tracer) Inject(w http.ResponseWriter, req *http.Request) Request {
+	if t == nil {
+		return Request{}
+	}
+	t.lock.RLock()
+	settings := t.settings
+	t.lock.RUnlock()
+	if settings == nil {
+		return Request{}
+	}
+	r := Request{Header: settings.RequestIDHeader}
+	r.ID = req.Header.Get(r.Header)
+	if r.ID == "" {
+		r.ID = NewRequestID()
+		req.Header.Set(r.Header, r.ID)
+	}
+	w.Header().Set(r.Header, r.ID)
+
+	if parent, ok := ParseTraceparent(req.Header.Get(TRACEPARENT_HEADER)); ok {
+		r.Span, r.Parent = parent, parent.SpanID
+	} else {
+		r.Span = SpanContext{TraceID: NewTraceID(), Flags: 1}
+		req.Header.Del(TRACESTATE_HEADER)
+	}
+	r.Span.SpanID = NewSpanID()
+	req.Header.Set(TRACEPARENT_HEADER, r.Span.Traceparent())
+	return r
+}
+
+//Parses a version 00 traceparent header. Returns false if the value is malformed or carries an
+//all zero trace or span ID. Higher versions are accepted when their first four fields parse.
+func ParseTraceparent(value string) (SpanContext, bool) {
+	var sc SpanContext
+	fields := strings.Split(strings.TrimSpace(value), "-")
+	if len(fields) < 4 || len(fields[0]) != 2 || len(fields[1]) != 32 || len(fields[2]) != 16 || len(fields[3]) != 2 {
+		return sc, false
+	}
+	if fields[0] == "ff" || (fields[0] == "00" && len(fields) != 4) {
+		return sc, false
+	}
+	var version, flags [1]byte
+	for _, f := range []struct {
+		dst []byte
+		src string
+	}{{version[:], fields[0]}, {sc.TraceID[:], fields[1]}, {sc.SpanID[:], fields[2]}, {flags[:], fields[3]}} {
+		if strings.ToLower(f.src) != f.src {
+			return sc, false
+		}
+		if _, err := hex.Decode(f.dst, []byte(f.src)); err != nil {
+			return sc, false
+		}
+	}
+	if sc.TraceID == ([16]byte{}) || sc.SpanID == ([8]byte{}) {
+		return sc, false
+	}
+	sc.Flags = flags[0]
+	return sc, true
+}
+
+//Formats the span context as a version 00 traceparent header.
+func (sc SpanContext) Traceparent() string {
+	return fmt.Sprintf("00-%x-%x-%02x", sc.TraceID, sc.SpanID, sc.Flags)
+}
+
+//Returns the trace ID as 32 lowercase hex digits.
+func (sc SpanContext) TraceIDString() string {
+	return hex.EncodeToString(sc.TraceID[:])
+}
+
+//Returns the span ID as 16 lowercase hex digits.
+func (sc SpanContext) SpanIDString() string {
+	return hex.EncodeToString(sc.SpanID[:])
+}
+
+//Generates a random version 4 UUID.
+func NewRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+//Generates a random non-zero trace ID.
+func NewTraceID() [16]byte {
+	var id [16]byte
+	for id == ([16]byte{}) {
+		rand.Read(id[:])
+	}
+	return id
+}
+
+//Generates a random non-zero span ID.
+func NewSpanID() [8]byte {
+	var id [8]byte
+	for id == ([8]byte{}) {
+		rand.Read(id[:])
+	}
+	return id
+}
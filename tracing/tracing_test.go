@@ -0,0 +1,186 @@
+package tracing_test
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/cbergoon/glb/tracing"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	sc, ok := tracing.ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if !ok {
+		t.Fatal("Expected valid traceparent")
+	}
+	if sc.TraceIDString() != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanIDString() != "00f067aa0ba902b7" || sc.Flags != 1 {
+		t.Error("Expected parsed fields got ", sc)
+	}
+	if sc.Traceparent() != "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01" {
+		t.Error("Expected round trip got ", sc.Traceparent())
+	}
+	if _, ok := tracing.ParseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-future"); !ok {
+		t.Error("Expected higher version with extra fields to be accepted")
+	}
+	for _, value := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473g-00f067aa0ba902b7-01",
+	} {
+		if _, ok := tracing.ParseTraceparent(value); ok {
+			t.Error("Expected invalid traceparent ", value)
+		}
+	}
+}
+
+func TestTracer_Inject(t *testing.T) {
+	var disabled *tracing.Tracer
+	req := httptest.NewRequest("GET", "/", nil)
+	if r := disabled.Inject(httptest.NewRecorder(), req); r.ID != "" || req.Header.Get("traceparent") != "" {
+		t.Error("Expected nil tracer to leave request untouched got ", r)
+	}
+	tracer := tracing.NewTracer()
+	if r := tracer.Inject(httptest.NewRecorder(), req); r.ID != "" {
+		t.Error("Expected unconfigured tracer to leave request untouched got ", r)
+	}
+
+	tracer.Configure(&tracing.Settings{})
+	w := httptest.NewRecorder()
+	r := tracer.Inject(w, req)
+	if !regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`).MatchString(r.ID) {
+		t.Error("Expected UUID request ID got ", r.ID)
+	}
+	if req.Header.Get(tracing.REQUEST_ID_HEADER) != r.ID || w.Header().Get(tracing.REQUEST_ID_HEADER) != r.ID {
+		t.Error("Expected request ID on request and response got ", req.Header, w.Header())
+	}
+	if r.Parent != ([8]byte{}) || req.Header.Get("traceparent") != r.Span.Traceparent() {
+		t.Error("Expected new trace got ", r, req.Header.Get("traceparent"))
+	}
+
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header = http.Header{"Traceparent": {"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00"}}
+	r = tracer.Inject(httptest.NewRecorder(), req)
+	if r.Span.TraceIDString() != "4bf92f3577b34da6a3ce929d0e0e4736" || r.Span.Flags != 0 || r.Parent != [8]byte{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7} {
+		t.Error("Expected child span of caller got ", r)
+	}
+}
+
+//Starts a collector stand-in that sends the spans of each export request to the channel.
+func collector(t *testing.T) (string, chan []map[string]interface{}) {
+	spans := make(chan []map[string]interface{}, 10)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/v1/traces" || req.Header.Get("Content-Type") != "application/json" || req.Header.Get("Authorization") != "Bearer secret" {
+			t.Error("Expected OTLP/HTTP JSON export request got ", req.URL.Path, req.Header)
+		}
+		var body struct {
+			ResourceSpans []struct {
+				Resource struct {
+					Attributes []map[string]interface{}
+				}
+				ScopeSpans []struct {
+					Spans []map[string]interface{}
+				}
+			}
+		}
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			t.Error("Expected JSON body got ", err)
+		}
+		if name := body.ResourceSpans[0].Resource.Attributes[0]; name["key"] != "service.name" || name["value"].(map[string]interface{})["stringValue"] != "edge" {
+			t.Error("Expected service.name resource attribute got ", name)
+		}
+		spans <- body.ResourceSpans[0].ScopeSpans[0].Spans
+	}))
+	t.Cleanup(s.Close)
+	return s.URL + "/v1/traces", spans
+}
+
+func TestTracer_Export(t *testing.T) {
+	endpoint, exported := collector(t)
+	tracer := tracing.NewTracer()
+	tracer.Configure(&tracing.Settings{Endpoint: endpoint, Headers: map[string]string{"Authorization": "Bearer secret"}, ServiceName: "edge", FlushIntervalSeconds: 60})
+	defer tracer.Configure(nil)
+
+	sc, _ := tracing.ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	server := tracer.StartSpan("proxy", tracing.SERVER, sc, [8]byte{1})
+	child := server.Child("round trip", tracing.CLIENT)
+	child.SetAttribute("glb.target", "localhost:8080")
+	child.SetAttribute("http.response.status_code", 502)
+	child.SetError(errors.New("bad gateway"))
+	child.End()
+	server.End()
+	server.End()
+	tracer.Flush()
+
+	spans := <-exported
+	if len(spans) != 2 {
+		t.Fatal("Expected 2 spans got ", spans)
+	}
+	if spans[0]["name"] != "round trip" || spans[0]["kind"] != 3.0 || spans[0]["traceId"] != "4bf92f3577b34da6a3ce929d0e0e4736" || spans[0]["parentSpanId"] != "00f067aa0ba902b7" {
+		t.Error("Expected child span got ", spans[0])
+	}
+	attributes := spans[0]["attributes"].([]interface{})
+	if len(attributes) != 2 || attributes[1].(map[string]interface{})["value"].(map[string]interface{})["intValue"] != "502" {
+		t.Error("Expected attributes got ", attributes)
+	}
+	if status := spans[0]["status"].(map[string]interface{}); status["code"] != 2.0 || status["message"] != "bad gateway" {
+		t.Error("Expected error status got ", status)
+	}
+	if spans[1]["name"] != "proxy" || spans[1]["spanId"] != "00f067aa0ba902b7" || spans[1]["parentSpanId"] != "0100000000000000" {
+		t.Error("Expected server span got ", spans[1])
+	}
+
+	sc.Flags = 0
+	if s := tracer.StartSpan("proxy", tracing.SERVER, sc, [8]byte{}); s != nil {
+		t.Error("Expected unsampled trace to have no span got ", s)
+	}
+	tracer.StartSpan("proxy", tracing.SERVER, server.SpanContext(), [8]byte{}).End()
+	tracer.Configure(&tracing.Settings{Endpoint: endpoint, Headers: map[string]string{"Authorization": "Bearer secret"}, ServiceName: "edge", FlushIntervalSeconds: 60})
+	select {
+	case spans := <-exported:
+		t.Error("Expected unchanged settings to keep the exporter and its queue got ", spans)
+	case <-time.After(100 * time.Millisecond):
+	}
+	tracer.Flush()
+	if spans := <-exported; len(spans) != 1 {
+		t.Error("Expected queued span to be exported got ", spans)
+	}
+	tracer.StartSpan("proxy", tracing.SERVER, server.SpanContext(), [8]byte{}).End()
+	tracer.Configure(&tracing.Settings{Endpoint: endpoint, Headers: map[string]string{"Authorization": "Bearer secret"}, ServiceName: "edge", FlushIntervalSeconds: 30})
+	select {
+	case spans := <-exported:
+		if len(spans) != 1 {
+			t.Error("Expected replaced exporter to export its queued span got ", spans)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Expected replaced exporter to export its queued span")
+	}
+	inFlight := tracer.StartSpan("proxy", tracing.SERVER, server.SpanContext(), [8]byte{})
+	tracer.Configure(&tracing.Settings{Endpoint: endpoint, Headers: map[string]string{"Authorization": "Bearer secret"}, ServiceName: "edge", FlushIntervalSeconds: 60})
+	time.Sleep(100 * time.Millisecond)
+	inFlight.End()
+	tracer.Flush()
+	select {
+	case spans := <-exported:
+		if len(spans) != 1 {
+			t.Error("Expected span ended after the exporter was replaced to be exported got ", spans)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Expected span ended after the exporter was replaced to be exported")
+	}
+	tracer.Configure(&tracing.Settings{})
+	sc.Flags = 1
+	if s := tracer.StartSpan("proxy", tracing.SERVER, sc, [8]byte{}); s != nil {
+		t.Error("Expected no span without an endpoint got ", s)
+	}
+	if err := (tracing.Settings{Endpoint: "localhost:4318"}).Validate(); err != tracing.ErrInvalidEndpoint {
+		t.Error("Expected ErrInvalidEndpoint got ", err)
+	}
+}